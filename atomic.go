@@ -0,0 +1,196 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrWrongType is matched by errors.Is when a value has the wrong type for an operation
+var ErrWrongType = errors.New("gost: value has the wrong type")
+
+// Incr adds delta to the integer stored in a key, and returns the new value
+// If the key doesn't exist, it's created as an int64 with the value of delta.
+// The value keeps its type, so an int stays an int, and it's an error if the
+// new value doesn't fit in it
+func (s *Store) Incr(ctx context.Context, uid string, key string, delta int64) (value int64, err error) {
+	ctx, done := s.operation(ctx, "Incr")
+	defer done(&err)
+	err = s.modifyKey(ctx, "incr", uid, key, func(current any, exists bool) (any, error) {
+		if !exists {
+			value = delta
+			return delta, nil
+		}
+		v := reflect.ValueOf(current)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = v.Int() + delta
+			if (delta > 0 && value < v.Int()) || (delta < 0 && value > v.Int()) || v.OverflowInt(value) {
+				return nil, fmt.Errorf("gost: incrementing %s by %d overflows %T", key, delta, current)
+			}
+			n := reflect.New(v.Type()).Elem()
+			n.SetInt(value)
+			return n.Interface(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u := int64(v.Uint())
+			value = u + delta
+			if u < 0 || value < 0 || (delta > 0 && value < u) || v.OverflowUint(uint64(value)) {
+				return nil, fmt.Errorf("gost: incrementing %s by %d overflows %T", key, delta, current)
+			}
+			n := reflect.New(v.Type()).Elem()
+			n.SetUint(uint64(value))
+			return n.Interface(), nil
+		}
+		return nil, fmt.Errorf("%w: %s is %T, not an integer", ErrWrongType, key, current)
+	})
+	return
+}
+
+// IncrFloat adds delta to the floating point number stored in a key, and
+// returns the new value
+// If the key doesn't exist, it's created as a float64 with the value of delta
+func (s *Store) IncrFloat(ctx context.Context, uid string, key string, delta float64) (value float64, err error) {
+	ctx, done := s.operation(ctx, "IncrFloat")
+	defer done(&err)
+	err = s.modifyKey(ctx, "incr", uid, key, func(current any, exists bool) (any, error) {
+		if !exists {
+			value = delta
+			return delta, nil
+		}
+		v := reflect.ValueOf(current)
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return nil, fmt.Errorf("%w: %s is %T, not a floating point number", ErrWrongType, key, current)
+		}
+		value = v.Float() + delta
+		n := reflect.New(v.Type()).Elem()
+		n.SetFloat(value)
+		return n.Interface(), nil
+	})
+	return
+}
+
+// Append adds values to the end of the slice stored in a key, and returns
+// the new length of the slice
+// The values must be assignable to the type of the slice's elements. If the
+// key doesn't exist, it's created as a slice of the type of the first value,
+// which needs to be registered if it's not a slice of a built-in type
+func (s *Store) Append(ctx context.Context, uid string, key string, values ...any) (length int, err error) {
+	ctx, done := s.operation(ctx, "Append")
+	defer done(&err)
+	if len(values) == 0 {
+		return 0, errors.New("gost: nothing to append")
+	}
+	err = s.modifyKey(ctx, "append", uid, key, func(current any, exists bool) (any, error) {
+		var slice reflect.Value
+		if exists {
+			slice = reflect.ValueOf(current)
+			if slice.Kind() != reflect.Slice {
+				return nil, fmt.Errorf("%w: %s is %T, not a slice", ErrWrongType, key, current)
+			}
+		} else {
+			if values[0] == nil {
+				return nil, errors.New("gost: cannot work out the type of the slice from nil")
+			}
+			slice = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(values[0])), 0, len(values))
+		}
+		elem := slice.Type().Elem()
+		for _, value := range values {
+			v := reflect.ValueOf(value)
+			if value == nil {
+				v = reflect.Zero(elem)
+			}
+			if !v.Type().AssignableTo(elem) {
+				return nil, fmt.Errorf("%w: cannot append %T to %s, which is %T", ErrWrongType, value, key, slice.Interface())
+			}
+			slice = reflect.Append(slice, v)
+		}
+		length = slice.Len()
+		return slice.Interface(), nil
+	})
+	return
+}
+
+// CompareAndSwap replaces the value stored in a key with new, but only if the
+// current value is equal to old, and reports whether it was replaced
+// Values are compared with reflect.DeepEqual. A nil old value matches a key
+// that doesn't exist
+func (s *Store) CompareAndSwap(ctx context.Context, uid string, key string, old any, new any) (swapped bool, err error) {
+	ctx, done := s.operation(ctx, "CompareAndSwap")
+	defer done(&err)
+	errUnchanged := errors.New("unchanged")
+	err = s.modifyKey(ctx, "compare-and-swap", uid, key, func(current any, exists bool) (any, error) {
+		if !reflect.DeepEqual(current, old) {
+			swapped = false
+			return nil, errUnchanged
+		}
+		swapped = true
+		return new, nil
+	})
+	if err == errUnchanged {
+		err = nil
+	}
+	return
+}
+
+// change the value stored in a key with fn, which is given the current value
+// and whether the key exists, and returns the new value
+// The change is recorded as the operation in the audit trail
+func (s *Store) modifyKey(ctx context.Context, op string, uid string, key string, fn func(current any, exists bool) (any, error)) (err error) {
+	err = ValidateKey(key)
+	if err != nil {
+		return
+	}
+	err = ValidateUID(uid)
+	if err != nil {
+		return
+	}
+	var old, new any
+	var existed bool
+	err = s.modify(ctx, uid, func(all map[string]any) (err error) {
+		current, exists := all[key]
+		value, err := fn(current, exists)
+		if err != nil {
+			return
+		}
+		all[key] = value
+		old, existed, new = current, exists, value
+		return
+	})
+	if err == nil {
+		s.keyChanged(ctx, op, uid, key, old, existed, new, true)
+	}
+	return
+}
+
+// delete a key, recording it as the operation in the audit trail and history
+func (s *Store) deleteKey(ctx context.Context, op string, uid string, key string) (err error) {
+	err = ValidateKey(key)
+	if err != nil {
+		return
+	}
+	err = ValidateUID(uid)
+	if err != nil {
+		return
+	}
+	var old any
+	var existed bool
+	err = s.modify(ctx, uid, func(all map[string]any) error {
+		old, existed = all[key]
+		delete(all, key)
+		return nil
+	})
+	if err == nil && existed {
+		s.keyChanged(ctx, op, uid, key, old, existed, nil, false)
+	}
+	return
+}
+
+// record a change to a key in the audit trail and history
+func (s *Store) keyChanged(ctx context.Context, op string, uid string, key string, old any, oldExists bool, new any, newExists bool) {
+	s.record(ctx, op, uid, key, hashValue(old), hashValue(new))
+	if s.history {
+		s.writeHistory(ctx, time.Now().UTC(), op, uid, key, old, oldExists, new, newExists)
+	}
+}
@@ -0,0 +1,177 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestIncr(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.DeleteAll(ctx, "incr-test")
+	if err != nil {
+		t.Errorf("Failed to delete all: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				_, err := store.Incr(ctx, "incr-test", "count", 1)
+				if err != nil {
+					t.Errorf("Failed to increment: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	count, err := store.Get(ctx, "incr-test", "count")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if count != int64(20) {
+		t.Errorf("Lost increments: %v", count)
+	}
+
+	err = store.Put(ctx, "incr-test", "small", int8(127))
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = store.Incr(ctx, "incr-test", "small", 1)
+	if err == nil {
+		t.Errorf("Incrementing should overflow")
+	}
+	_, err = store.Incr(ctx, "incr-test", "name", 1)
+	if err != nil {
+		t.Errorf("Failed to increment: %v", err)
+	}
+	err = store.Put(ctx, "incr-test", "name", "Bob")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = store.Incr(ctx, "incr-test", "name", 1)
+	if !errors.Is(err, ErrWrongType) {
+		t.Errorf("Incrementing a string should fail: %v", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.DeleteAll(ctx, "append-test")
+	if err != nil {
+		t.Errorf("Failed to delete all: %v", err)
+	}
+	_, err = store.Append(ctx, "append-test", "badges", "first-post")
+	if err != nil {
+		t.Errorf("Failed to append: %v", err)
+	}
+	length, err := store.Append(ctx, "append-test", "badges", "ten-posts", "hundred-posts")
+	if err != nil {
+		t.Errorf("Failed to append: %v", err)
+	}
+	if length != 3 {
+		t.Errorf("Wrong length: %v", length)
+	}
+	_, err = store.Append(ctx, "append-test", "badges", 1)
+	if !errors.Is(err, ErrWrongType) {
+		t.Errorf("Appending an int to strings should fail: %v", err)
+	}
+	badges, err := store.Get(ctx, "append-test", "badges")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if b, ok := badges.([]string); !ok || len(b) != 3 || b[2] != "hundred-posts" {
+		t.Errorf("Failed to get the right thing: %v", badges)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.DeleteAll(ctx, "cas-test")
+	if err != nil {
+		t.Errorf("Failed to delete all: %v", err)
+	}
+	swapped, err := store.CompareAndSwap(ctx, "cas-test", "plan", nil, "free")
+	if err != nil || !swapped {
+		t.Errorf("Failed to swap a missing key: %v, %v", swapped, err)
+	}
+	swapped, err = store.CompareAndSwap(ctx, "cas-test", "plan", "pro", "enterprise")
+	if err != nil || swapped {
+		t.Errorf("Should not swap a different value: %v, %v", swapped, err)
+	}
+	swapped, err = store.CompareAndSwap(ctx, "cas-test", "plan", "free", "pro")
+	if err != nil || !swapped {
+		t.Errorf("Failed to swap: %v, %v", swapped, err)
+	}
+	plan, err := store.Get(ctx, "cas-test", "plan")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if plan != "pro" {
+		t.Errorf("Failed to get the right thing: %v", plan)
+	}
+}
+
+func TestConditionalWrite(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "conditional-test", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.writeMap(ifMatch(ctx, "not-the-etag"), name("conditional-test"), map[string]any{})
+	if !isPreconditionFailed(err) {
+		t.Errorf("Write with the wrong ETag should fail: %v", err)
+	}
+	err = store.writeMap(ifNoneMatch(ctx), name("conditional-test"), map[string]any{})
+	if !isPreconditionFailed(err) {
+		t.Errorf("Write to an existing object should fail: %v", err)
+	}
+}
+
+func TestFinishesWrite(t *testing.T) {
+	tests := []struct {
+		method   string
+		query    string
+		finishes bool
+	}{
+		{http.MethodPut, "", true},
+		{http.MethodDelete, "", true},
+		{http.MethodPost, "uploads=", false},
+		{http.MethodPut, "partNumber=1&uploadId=abc", false},
+		{http.MethodPost, "uploadId=abc", true},
+		{http.MethodDelete, "uploadId=abc", false},
+		{http.MethodGet, "", false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "http://localhost/bucket/object?"+test.query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if finishesWrite(req) != test.finishes {
+			t.Errorf("Wrong answer for whether %s with %q finishes a write, should be %v", test.method, test.query, test.finishes)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package gost
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// the prefix of the objects that hold audit records
+const auditPrefix = gostPrefix + "audit/"
+
+// the format of the timestamps in audit record names, which sort in time order
+const auditTimeFormat = "20060102T150405.000000000Z"
+
+// WithAudit makes the store write an audit record for every change to data
+// and published files, which can be read back with AuditLog
+func WithAudit() Option {
+	return func(s *Store) {
+		s.audit = true
+	}
+}
+
+type actorKey struct{}
+
+// ContextWithActor returns a context that records the actor, like the user
+// or service making a change, in the audit records of changes made with it
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+type withoutAuditKey struct{}
+
+// make changes with the context without writing audit records, for changes
+// that remove the records they would be written about
+func withoutAudit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutAuditKey{}, true)
+}
+
+// ActorFromContext returns the actor recorded in the context, if there is one
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// AuditRecord is a record of a change to the data for a unique ID, or to a
+// published file
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor,omitempty"`
+	// put, delete, delete-all, restore, incr, append, compare-and-swap,
+	// publish or unpublish
+	Op string `json:"op"`
+	// empty for published files
+	UID string `json:"uid,omitempty"`
+	// the key, or the filename of a published file, empty if all the data
+	// for the unique ID changed
+	Key string `json:"key,omitempty"`
+	// SHA-256 hashes of the value before and after the change, empty if
+	// there was no value
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash,omitempty"`
+	// the owner of a published file, if it has one, so PurgeUser can find
+	// the records of the files a unique ID published
+	Owner string `json:"owner,omitempty"`
+}
+
+// get the prefix of the audit records for a unique ID, or for published files
+// if the unique ID is empty
+func auditObjects(uid string) string {
+	if uid == "" {
+		return auditPrefix + "public/"
+	}
+	return auditPrefix + "users/" + encode(uid) + "/"
+}
+
+// hash a value, the same way every time for equal values
+// Gob writes maps in random order, so the value is written out for hashing
+// with its map entries sorted rather than hashed the way it's stored
+func hashValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	h := sha256.New()
+	writeCanonical(h, reflect.ValueOf(v))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// write a value with its types, the same way for equal values
+// Pointers and interfaces are followed, nil and empty slices and maps are
+// written the same way as gob doesn't tell them apart, and types that encode
+// themselves, like time.Time, are written the way they encode themselves
+func writeCanonical(w io.Writer, v reflect.Value) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			io.WriteString(w, "nil;")
+			return
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		io.WriteString(w, "nil;")
+		return
+	}
+	fmt.Fprintf(w, "%s:", v.Type())
+	if data, ok := selfEncoded(v); ok {
+		fmt.Fprintf(w, "%q;", data)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		fmt.Fprintf(w, "%t;", v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(w, "%d;", v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		fmt.Fprintf(w, "%d;", v.Uint())
+	case reflect.Float32, reflect.Float64:
+		fmt.Fprintf(w, "%v;", v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		fmt.Fprintf(w, "%v;", v.Complex())
+	case reflect.String:
+		fmt.Fprintf(w, "%q;", v.String())
+	case reflect.Slice, reflect.Array:
+		fmt.Fprintf(w, "%d[", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			writeCanonical(w, v.Index(i))
+		}
+		io.WriteString(w, "];")
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var entry strings.Builder
+			writeCanonical(&entry, iter.Key())
+			writeCanonical(&entry, iter.Value())
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		fmt.Fprintf(w, "%d{", len(entries))
+		for _, entry := range entries {
+			io.WriteString(w, entry)
+		}
+		io.WriteString(w, "};")
+	case reflect.Struct:
+		io.WriteString(w, "{")
+		for i := 0; i < v.NumField(); i++ {
+			// gob leaves out unexported fields
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			fmt.Fprintf(w, "%s=", v.Type().Field(i).Name)
+			writeCanonical(w, v.Field(i))
+		}
+		io.WriteString(w, "};")
+	default:
+		// channels and functions can't be stored, so only their type is written
+		io.WriteString(w, ";")
+	}
+}
+
+// encode a value of a type that encodes itself for gob
+func selfEncoded(v reflect.Value) (data []byte, ok bool) {
+	if !v.CanInterface() {
+		return
+	}
+	var err error
+	switch m := v.Interface().(type) {
+	case gob.GobEncoder:
+		data, err = m.GobEncode()
+	case encoding.BinaryMarshaler:
+		data, err = m.MarshalBinary()
+	default:
+		return
+	}
+	return data, err == nil
+}
+
+// hash all the data for a unique ID, from the hashes of its values in key order
+func hashMap(data map[string]any) string {
+	if len(data) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "\x00" + hashValue(data[k]) + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hash the published file, empty if it's not published
+// Content-addressed files have their hash in their metadata, others are read
+func (s *Store) publishedHash(ctx context.Context, filename string) (hash string, err error) {
+	obj, err := s.openObject(ctx, "public/"+filename, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get published object:", err)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		if isNoSuchKey(err) {
+			err = nil
+		} else {
+			log.Println("Cannot check published object:", err)
+		}
+		return
+	}
+	if hash = info.UserMetadata[hashMeta]; hash != "" {
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, obj)
+	if err != nil {
+		log.Println("Cannot read published object:", err)
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// write an audit record, if the store is audited
+// Records are never overwritten, each one gets an object of its own. The
+// record is written after the change has been made, so failing to write it
+// is only logged, and doesn't make the change look like it failed
+func (s *Store) record(ctx context.Context, op string, uid string, key string, oldHash string, newHash string) {
+	s.writeRecord(ctx, AuditRecord{Op: op, UID: uid, Key: key, OldHash: oldHash, NewHash: newHash})
+}
+
+// write an audit record of a change to a published file with an owner, if the store is audited
+func (s *Store) recordPublished(ctx context.Context, op string, filename string, owner string, oldHash string, newHash string) {
+	s.writeRecord(ctx, AuditRecord{Op: op, Key: filename, OldHash: oldHash, NewHash: newHash, Owner: owner})
+}
+
+// write an audit record with the time and actor, unless the store isn't
+// audited or the context is without audit records
+func (s *Store) writeRecord(ctx context.Context, rec AuditRecord) {
+	if !s.audit || ctx.Value(withoutAuditKey{}) != nil {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	rec.Actor = ActorFromContext(ctx)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("Cannot encode audit record:", err)
+		return
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	objectName := auditObjects(rec.UID) + rec.Time.Format(auditTimeFormat) + "-" + hex.EncodeToString(suffix) + ".json"
+	_, err = s.upload(ifNoneMatch(ctx), objectName, b, minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		log.Println("Cannot write audit record:", err)
+	}
+}
+
+// AuditLog reads the audit records of changes to the data for a unique ID
+// since the given time, oldest first
+// The records of changes to published files are read with an empty unique ID
+func (s *Store) AuditLog(ctx context.Context, uid string, since time.Time) (records []AuditRecord, err error) {
+	ctx, done := s.operation(ctx, "AuditLog")
+	defer done(&err)
+	if uid != "" {
+		err = ValidateUID(uid)
+		if err != nil {
+			return
+		}
+	}
+	prefix := auditObjects(uid)
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+	if !since.IsZero() {
+		// names start with the time, so skip everything before it
+		opts.StartAfter = prefix + since.UTC().Format(auditTimeFormat)
+	}
+	for info := range s.listObjects(ctx, opts) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list audit records:", err)
+			return
+		}
+		var rec AuditRecord
+		rec, err = s.readRecord(ctx, info.Key)
+		if err != nil {
+			return
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return
+}
+
+// read an audit record
+func (s *Store) readRecord(ctx context.Context, objectName string) (rec AuditRecord, err error) {
+	obj, err := s.openObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get audit record:", err)
+		return
+	}
+	defer obj.Close()
+	b, err := io.ReadAll(obj)
+	if err != nil {
+		log.Println("Cannot read audit record:", err)
+		return
+	}
+	err = json.Unmarshal(b, &rec)
+	if err != nil {
+		log.Println("Cannot decode audit record:", err)
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package gost
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithAudit())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := ContextWithActor(context.Background(), "admin@example.com")
+	since := time.Now()
+	err = store.Put(ctx, "audit-test", "plan", "free")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Put(ctx, "audit-test", "plan", "pro")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Delete(ctx, "audit-test", "plan")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+	err = store.DeleteAll(ctx, "audit-test")
+	if err != nil {
+		t.Errorf("Failed to delete all: %v", err)
+	}
+	records, err := store.AuditLog(ctx, "audit-test", since)
+	if err != nil {
+		t.Errorf("Failed to read the audit log: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Wrong number of records: %+v", records)
+	}
+	ops := []string{"put", "put", "delete", "delete-all"}
+	for i, rec := range records {
+		if rec.Op != ops[i] || rec.Actor != "admin@example.com" || rec.UID != "audit-test" {
+			t.Errorf("Wrong record: %+v", rec)
+		}
+	}
+	if records[0].OldHash != "" || records[0].NewHash == "" || records[1].OldHash != records[0].NewHash {
+		t.Errorf("Wrong hashes: %+v, %+v", records[0], records[1])
+	}
+	if records[2].OldHash != records[1].NewHash || records[2].NewHash != "" {
+		t.Errorf("Wrong hashes: %+v", records[2])
+	}
+
+	records, err = store.AuditLog(ctx, "audit-test", time.Now())
+	if err != nil {
+		t.Errorf("Failed to read the audit log: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Records should be read since the given time: %+v", records)
+	}
+}
+
+func TestAuditPublish(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithAudit())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	imageBytes, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Errorf("Failed to read test.png: %v", err)
+	}
+	ctx := ContextWithActor(context.Background(), "admin@example.com")
+	since := time.Now()
+	_, _, err = store.Publish(ctx, "audit-test.png", "image/png", imageBytes)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	err = store.Unpublish(ctx, "audit-test.png")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	records, err := store.AuditLog(ctx, "", since)
+	if err != nil {
+		t.Errorf("Failed to read the audit log: %v", err)
+	}
+	if len(records) != 2 || records[0].Op != "publish" || records[1].Op != "unpublish" {
+		t.Fatalf("Wrong records: %+v", records)
+	}
+	if records[0].NewHash == "" || records[1].OldHash != records[0].NewHash {
+		t.Errorf("Wrong hashes: %+v", records)
+	}
+}
+
+func TestHashValue(t *testing.T) {
+	value := map[string]any{"theme": "dark", "lang": "en", "size": 12, "tags": []any{"a", "b"}}
+	hash := hashValue(value)
+	// gob writes maps in random order, so encoding them many times should
+	// give different orders but the same hash
+	for i := 0; i < 50; i++ {
+		if h := hashValue(map[string]any{"tags": []any{"a", "b"}, "size": 12, "lang": "en", "theme": "dark"}); h != hash {
+			t.Fatalf("Equal maps should hash the same: %v, %v", h, hash)
+		}
+	}
+	if hashValue(map[string]any{"theme": "light", "lang": "en", "size": 12, "tags": []any{"a", "b"}}) == hash {
+		t.Errorf("Different maps should not hash the same")
+	}
+	if hashValue(12) == hashValue(int64(12)) || hashValue("12") == hashValue(12) {
+		t.Errorf("Values of different types should not hash the same")
+	}
+	now := time.Now()
+	if hashValue(now) != hashValue(now.Round(0)) {
+		t.Errorf("Times should hash the way they're stored")
+	}
+}
@@ -5,79 +5,648 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 )
 
+// the number of generations in a chain of diffs and the full backup they
+// start from, after which the next backup is a full one again
+const fullBackupEvery = 10
+
+// user metadata with the hash of all the data in a backup generation
+const backupHashMeta = "Gost-Backup-Hash"
+
+// WithBackupStore makes the store keep its backups, backup manifests and
+// checkpoints in another store, like one in an isolated bucket, instead of
+// in its own bucket
+// Backup generations are never overwritten, so the bucket can have object
+// locking turned on
+func WithBackupStore(target *Store) Option {
+	return func(s *Store) {
+		s.backupStore = target
+	}
+}
+
+// get the store backups are kept in
+func (s *Store) backups() *Store {
+	if s.backupStore != nil {
+		return s.backupStore
+	}
+	return s
+}
+
+// ErrNoSuchGeneration is returned when a unique ID has no backup generation with the given number
+var ErrNoSuchGeneration = errors.New("gost: no such backup generation")
+
+// get the name of the single backup used before backups had generations
 func backup(uid string) string {
+	return "backup/" + encode(uid) + ".gob"
+}
+
+// get the name of the backup used before unique IDs were URL-safe encoded
+func legacyBackup(uid string) string {
 	return "backup/" + base64.StdEncoding.EncodeToString([]byte(uid)) + ".gob"
 }
 
+// get the prefix of the backup generations for a unique ID
+func backupGenerations(uid string) string {
+	return "backup/" + encode(uid) + "/"
+}
+
+// check if the object is a backup generation
+// Legacy backups can have / in their names too, but not -, so they never
+// look like generations
+func isGeneration(objectName string) bool {
+	segments := strings.Split(objectName, "/")
+	if len(segments) != 3 || segments[0] != "backup" {
+		return false
+	}
+	number, kind, ok := strings.Cut(strings.TrimSuffix(segments[2], ".gob"), "-")
+	_, err := strconv.Atoi(number)
+	return ok && err == nil && (kind == "full" || kind == "diff")
+}
+
+// get the unique ID a backup or backup generation is for
+func backupUID(objectName string) (uid string, err error) {
+	encoded := strings.TrimSuffix(strings.TrimPrefix(objectName, "backup/"), ".gob")
+	if isGeneration(objectName) {
+		encoded = strings.Split(objectName, "/")[1]
+	}
+	return decode(encoded)
+}
+
+// get the name of a backup generation
+func generationName(uid string, gen int, full bool) string {
+	kind := "diff"
+	if full {
+		kind = "full"
+	}
+	return fmt.Sprintf("%s%010d-%s.gob", backupGenerations(uid), gen, kind)
+}
+
+// BackupGeneration describes a backup of the data for a unique ID
+// Full generations have all the data, the others only have what changed
+// since the generation before, and are loaded by replaying the changes since
+// the last full generation
+type BackupGeneration struct {
+	// Generations are numbered from 1, generation 0 is the single backup
+	// taken before backups had generations
+	Generation int
+	Full       bool
+	Time       time.Time
+	Size       int64
+	// the name of the object holding the generation
+	Object string
+}
+
+// the start of a backup generation object, which can be read without reading the values
+type generationHeader struct {
+	Codec int
+	Full  bool
+	// keys removed since the generation before, for diffs
+	Removed []string
+	// hashes of every value in the data as it was backed up, so the next diff
+	// can be worked out without loading the backup
+	Hashes map[string]string
+}
+
 // Backup all the data for a given unique ID
-// There is only 1 backup per unique ID, each time Backup is called, the previous backup is overwritten
+// Each backup is a new generation. If nothing has changed since the last
+// generation, no new one is taken. Otherwise only the keys that changed are
+// stored, with a full backup taken every few generations
+// Backups go to the backup store if the store was created with WithBackupStore
 func (s *Store) Backup(ctx context.Context, uid string) (err error) {
+	ctx, done := s.operation(ctx, "Backup")
+	defer done(&err)
+	_, _, err = s.backupTo(ctx, uid, s.backups())
+	return
+}
+
+// BackupTo backs up all the data for a given unique ID into another store,
+// like one in another bucket or with another provider
+func (s *Store) BackupTo(ctx context.Context, uid string, target *Store) (err error) {
+	ctx, done := s.operation(ctx, "BackupTo")
+	defer done(&err)
+	_, _, err = s.backupTo(ctx, uid, target)
+	return
+}
+
+// back up all the data for a given unique ID into the target store, and
+// return the latest generation and its ETag, whether or not a new one was taken
+func (s *Store) backupTo(ctx context.Context, uid string, target *Store) (latest BackupGeneration, etag string, err error) {
 	all, err := s.GetAll(ctx, uid)
 	if err != nil {
 		log.Println("Cannot get data during backup:", err)
+		return
+	}
+	gens, err := target.generations(ctx, uid)
+	if err != nil {
+		return
+	}
+	var last BackupGeneration
+	if len(gens) > 0 {
+		last = gens[len(gens)-1]
+	}
+	hashes := make(map[string]string, len(all))
+	for k, v := range all {
+		hashes[k] = hashValue(v)
+	}
+
+	header := generationHeader{Codec: codecVersion, Full: true, Hashes: hashes}
+	values := all
+	// generation 0 has no hashes, so the first generation after it is full
+	if last.Generation > 0 {
+		var info minio.ObjectInfo
+		info, err = target.statObject(ctx, last.Object)
+		if err != nil {
+			log.Println("Cannot check backup:", err)
+			return
+		}
+		if info.UserMetadata[backupHashMeta] == hashMap(all) {
+			// nothing has changed
+			return last, info.ETag, nil
+		}
+		if chain(gens) < fullBackupEvery {
+			var previous generationHeader
+			previous, _, _, err = target.readGeneration(ctx, last.Object, false)
+			if err != nil {
+				return
+			}
+			header.Full = false
+			values = make(map[string]any)
+			for k, hash := range hashes {
+				if previous.Hashes[k] != hash {
+					values[k] = all[k]
+				}
+			}
+			for k := range previous.Hashes {
+				if _, ok := all[k]; !ok {
+					header.Removed = append(header.Removed, k)
+				}
+			}
+			sort.Strings(header.Removed)
+		}
+	}
+	latest = BackupGeneration{Generation: last.Generation + 1, Full: header.Full, Object: generationName(uid, last.Generation+1, header.Full)}
+	info, err := target.writeGeneration(ctx, latest.Object, header, values, hashMap(all))
+	if err != nil {
+		return
+	}
+	latest.Time, latest.Size = time.Now(), info.Size
+	return latest, info.ETag, nil
+}
+
+// count the generations since the last full one, including it
+func chain(gens []BackupGeneration) (n int) {
+	for i := len(gens) - 1; i >= 0; i-- {
+		n++
+		if gens[i].Full {
+			return
+		}
+	}
+	return
+}
+
+// write a backup generation, as the header followed by the values
+// Generations are never overwritten, so two backups taken at the same time
+// can't both write the same generation
+func (s *Store) writeGeneration(ctx context.Context, objectName string, header generationHeader, values map[string]any, hash string) (info minio.UploadInfo, err error) {
+	stored := make(map[string]envelope, len(values))
+	for k, v := range values {
+		stored[k], err = encodeValue(v)
+		if err != nil {
+			log.Println("Cannot encode gob:", err)
+			return
+		}
 	}
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(all)
+	err = enc.Encode(header)
+	if err == nil {
+		err = enc.Encode(stored)
+	}
 	if err != nil {
 		log.Println("Cannot encode gob:", err)
+		return
+	}
+	metadata := codecMetadata()
+	metadata[backupHashMeta] = hash
+	metadata[backupSumMeta] = backupSum(buf.Bytes())
+	info, err = s.upload(ifNoneMatch(ctx), objectName, buf.Bytes(),
+		minio.PutObjectOptions{ContentType: "application/octet-stream", UserMetadata: metadata})
+	if err != nil {
+		log.Println("Cannot put backup:", err)
+	}
+	return
+}
+
+// read a backup generation, and its values if withValues is set
+// The generation is checked against its checksum when its values are read
+// The values are stale if any were upgraded to newer versions of their types
+func (s *Store) readGeneration(ctx context.Context, objectName string, withValues bool) (header generationHeader, values map[string]any, stale bool, err error) {
+	obj, err := s.openObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get backup:", err)
+		return
+	}
+	defer obj.Close()
+	var r io.Reader = obj
+	if withValues {
+		var info minio.ObjectInfo
+		info, err = obj.Stat()
+		if err != nil {
+			log.Println("Cannot check backup:", err)
+			return
+		}
+		var body []byte
+		body, err = io.ReadAll(obj)
+		if err != nil {
+			log.Println("Cannot read backup:", err)
+			return
+		}
+		err = checkBackup(objectName, info.UserMetadata, body)
+		if err != nil {
+			log.Println("Cannot verify backup:", err)
+			return
+		}
+		r = bytes.NewReader(body)
+	}
+	header, values, stale, err = decodeGeneration(r, withValues)
+	err = inObject(err, objectName)
+	return
+}
+
+// decode a backup generation, and its values if withValues is set
+func decodeGeneration(r io.Reader, withValues bool) (header generationHeader, values map[string]any, stale bool, err error) {
+	dec := gob.NewDecoder(r)
+	err = dec.Decode(&header)
+	if err != nil {
+		log.Println("Cannot decode backup:", err)
+		return
+	}
+	if !withValues {
+		return
+	}
+	var stored map[string]envelope
+	err = dec.Decode(&stored)
+	if err != nil {
+		log.Println("Cannot decode backup:", err)
+		return
+	}
+	values = make(map[string]any, len(stored))
+	var unregistered *UnregisteredTypeError
+	for k, e := range stored {
+		var migrated bool
+		values[k], migrated, err = decodeValue(e)
+		if u, ok := err.(*UnregisteredTypeError); ok {
+			unregistered = unregistered.add(u.Types...)
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot decode backup:", err)
+			return
+		}
+		stale = stale || migrated
 	}
-	s.client.PutObject(ctx, s.bucket, backup(uid), &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if unregistered != nil {
+		err = unregistered
+		log.Println("Cannot decode backup:", err)
+	}
+	return
+}
+
+// Generations lists the backup generations for a given unique ID, oldest first
+func (s *Store) Generations(ctx context.Context, uid string) (gens []BackupGeneration, err error) {
+	ctx, done := s.operation(ctx, "Generations")
+	defer done(&err)
+	return s.backups().generations(ctx, uid)
+}
+
+// list the backup generations for a given unique ID in this store
+func (s *Store) generations(ctx context.Context, uid string) (gens []BackupGeneration, err error) {
+	err = ValidateUID(uid)
 	if err != nil {
-		log.Println("Cannot put object:", err)
+		return
+	}
+	// the single backup from before generations is generation 0
+	for _, objectName := range unique(backup(uid), legacyBackup(uid)) {
+		var info minio.ObjectInfo
+		info, err = s.statObject(ctx, objectName)
+		if isNoSuchKey(err) {
+			err = nil
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot check backup:", err)
+			return
+		}
+		gens = append(gens, BackupGeneration{Full: true, Time: info.LastModified, Size: info.Size, Object: objectName})
+		break
+	}
+	prefix := backupGenerations(uid)
+	for info := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list backups:", err)
+			return
+		}
+		number, kind, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(info.Key, prefix), ".gob"), "-")
+		gen, convErr := strconv.Atoi(number)
+		if !ok || convErr != nil {
+			continue
+		}
+		gens = append(gens, BackupGeneration{
+			Generation: gen,
+			Full:       kind == "full",
+			Time:       info.LastModified,
+			Size:       info.Size,
+			Object:     info.Key,
+		})
+	}
+	return
+}
+
+// Retention says which backup generations Prune keeps
+// A generation is kept if either rule keeps it, and so are the generations
+// before it back to the last full one, which are needed to load it. The
+// latest generation is always kept
+type Retention struct {
+	// Keep this many of the latest generations, none by count if it's zero
+	Generations int
+	// Keep the generations taken within this long, none by age if it's zero
+	MaxAge time.Duration
+}
+
+// Prune removes the backup generations for a given unique ID that the
+// retention doesn't keep, oldest first, and returns how many it removed
+// Nothing is removed if the retention is zero
+func (s *Store) Prune(ctx context.Context, uid string, retention Retention) (removed int, err error) {
+	ctx, done := s.operation(ctx, "Prune")
+	defer done(&err)
+	return s.backups().prune(ctx, uid, retention)
+}
+
+// remove the backup generations for a given unique ID in this store that the retention doesn't keep
+func (s *Store) prune(ctx context.Context, uid string, retention Retention) (removed int, err error) {
+	if retention.Generations <= 0 && retention.MaxAge <= 0 {
+		return
+	}
+	gens, err := s.generations(ctx, uid)
+	if err != nil || len(gens) == 0 {
+		return
+	}
+	// the first generation kept
+	keep := len(gens) - 1
+	if retention.Generations > 0 && len(gens)-retention.Generations < keep {
+		keep = len(gens) - retention.Generations
+		if keep < 0 {
+			keep = 0
+		}
+	}
+	if retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-retention.MaxAge)
+		for keep > 0 && gens[keep-1].Time.After(cutoff) {
+			keep--
+		}
+	}
+	for keep > 0 && !gens[keep].Full {
+		keep--
+	}
+	for _, g := range gens[:keep] {
+		err = s.removeObject(ctx, g.Object)
+		if err != nil {
+			log.Println("Cannot remove backup:", err)
+			return
+		}
+		removed++
 	}
 	return
 }
 
-// Load all the data from the backup for a given unique ID
+// Load all the data from the latest backup for a given unique ID
 // You can use this to restore data from a backup
 // You can also use this to view the data in the backup without restoring it
 func (s *Store) Load(ctx context.Context, uid string) (data map[string]any, err error) {
-	obj, err := s.client.GetObject(ctx, s.bucket, backup(uid), minio.GetObjectOptions{})
+	ctx, done := s.operation(ctx, "Load")
+	defer done(&err)
+	return s.LoadGeneration(ctx, uid, -1)
+}
+
+// LoadGeneration loads all the data from a backup generation for a given
+// unique ID, or from the latest one if gen is negative
+// If there are no backups, the data is empty
+func (s *Store) LoadGeneration(ctx context.Context, uid string, gen int) (data map[string]any, err error) {
+	ctx, done := s.operation(ctx, "LoadGeneration")
+	defer done(&err)
+	data, err = s.backups().loadGeneration(ctx, uid, gen)
+	if gen < 0 && errors.Is(err, ErrNoSuchGeneration) {
+		err = nil
+	}
+	return
+}
+
+// load all the data from a backup generation for a given unique ID in this store
+// If there are no backups, the data is empty and the error is ErrNoSuchGeneration
+func (s *Store) loadGeneration(ctx context.Context, uid string, gen int) (data map[string]any, err error) {
+	gens, err := s.generations(ctx, uid)
 	if err != nil {
-		data = make(map[string]any)
-		log.Println("Backup doesn't exist:", err)
 		return
 	}
-	defer obj.Close()
-	_, err = obj.Stat()
+	if len(gens) == 0 {
+		return make(map[string]any), ErrNoSuchGeneration
+	}
+	end := len(gens) - 1
+	if gen >= 0 {
+		end = sort.Search(len(gens), func(i int) bool { return gens[i].Generation >= gen })
+		if end == len(gens) || gens[end].Generation != gen {
+			err = ErrNoSuchGeneration
+			return
+		}
+	}
+	start := end
+	for !gens[start].Full && start > 0 {
+		start--
+	}
+	if !gens[start].Full {
+		err = fmt.Errorf("%w: backup generation %d has no full backup to start from", ErrCorruptBackup, gens[end].Generation)
+		return
+	}
+	err = checkChain(gens[start : end+1])
 	if err != nil {
-		data = make(map[string]any)
-		log.Println("Object doesn't exist:", err, obj)
 		return
 	}
+	return s.replay(ctx, gens[start:end+1])
+}
 
-	decoder := gob.NewDecoder(obj)
-	err = decoder.Decode(&data)
-	if err != nil {
-		log.Println("Cannot decode data:", err)
+// load the data from a full backup generation and the diffs after it
+func (s *Store) replay(ctx context.Context, gens []BackupGeneration) (data map[string]any, err error) {
+	if gens[0].Generation == 0 {
+		data, _, _, err = s.readMap(ctx, gens[0].Object)
+		return
+	}
+	data = make(map[string]any)
+	for _, g := range gens {
+		var header generationHeader
+		var values map[string]any
+		header, values, _, err = s.readGeneration(ctx, g.Object, true)
+		if err != nil {
+			return
+		}
+		for _, k := range header.Removed {
+			delete(data, k)
+		}
+		for k, v := range values {
+			data[k] = v
+		}
 	}
 	return
 }
 
-// Restore data from the backup for a given unique ID
-// This will overwrite the current data for the unique ID
+// BackupDiff lists the keys a restore changes, compared with the data as it is now
+type BackupDiff struct {
+	// keys in the backup that aren't in the data, which a restore adds back
+	Added []string
+	// keys in the data that aren't in the backup, which a restore removes
+	Removed []string
+	// keys whose values in the backup are different, which a restore changes back
+	Changed []string
+}
+
+// RestoreOptions configures RestoreWith
+type RestoreOptions struct {
+	// Work out what the restore would change without changing anything
+	DryRun bool
+	// Only restore these keys, all of them if it's empty
+	Keys []string
+	// Keep the keys that aren't in the backup, instead of removing them
+	Merge bool
+}
+
+// Restore data from the latest backup for a given unique ID
+// This will overwrite the current data for the unique ID, unless the backup
+// fails verification, in which case the error wraps ErrCorruptBackup and the
+// data is left as it is. If there are no backups, the error is
+// ErrNoSuchGeneration, rather than all the data being removed
+// Backups come from the backup store if the store was created with WithBackupStore
 func (s *Store) Restore(ctx context.Context, uid string) (err error) {
-	all, err := s.Load(ctx, uid)
+	ctx, done := s.operation(ctx, "Restore")
+	defer done(&err)
+	_, err = s.restoreFrom(ctx, uid, s.backups(), -1, RestoreOptions{})
+	return
+}
+
+// RestoreFrom restores data for a given unique ID from the latest backup in
+// another store, taken with BackupTo
+// This will overwrite the current data for the unique ID
+func (s *Store) RestoreFrom(ctx context.Context, uid string, source *Store) (err error) {
+	ctx, done := s.operation(ctx, "RestoreFrom")
+	defer done(&err)
+	_, err = s.restoreFrom(ctx, uid, source, -1, RestoreOptions{})
+	return
+}
+
+// RestoreWith restores data for a given unique ID from the latest backup,
+// like Restore, and returns the keys it changed
+// With a dry run, nothing is changed and the keys that would be changed are
+// returned instead
+func (s *Store) RestoreWith(ctx context.Context, uid string, opts RestoreOptions) (diff BackupDiff, err error) {
+	ctx, done := s.operation(ctx, "RestoreWith")
+	defer done(&err)
+	return s.restoreFrom(ctx, uid, s.backups(), -1, opts)
+}
+
+// DiffBackup compares the data for a given unique ID with its latest backup,
+// showing what Restore would change
+func (s *Store) DiffBackup(ctx context.Context, uid string) (diff BackupDiff, err error) {
+	ctx, done := s.operation(ctx, "DiffBackup")
+	defer done(&err)
+	return s.RestoreWith(ctx, uid, RestoreOptions{DryRun: true})
+}
+
+// restore data from a backup generation in the source store for a given
+// unique ID, or from the latest one if gen is negative
+// It's an error if there are no backups, which would otherwise look like a
+// backup of no data
+func (s *Store) restoreFrom(ctx context.Context, uid string, source *Store, gen int, opts RestoreOptions) (diff BackupDiff, err error) {
+	for _, key := range opts.Keys {
+		err = ValidateKey(key)
+		if err != nil {
+			return
+		}
+	}
+	backup, err := source.loadGeneration(ctx, uid, gen)
 	if err != nil {
 		log.Println("Cannot get data during restore:", err)
+		return
 	}
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(all)
-	if err != nil {
-		log.Println("Cannot encode gob:", err)
+	if opts.DryRun {
+		var all map[string]any
+		all, _, _, err = s.getAll(ctx, uid)
+		if err != nil {
+			return
+		}
+		return restoreDiff(all, backup, opts), nil
 	}
-	s.client.PutObject(ctx, s.bucket, name(uid), &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	var old, all map[string]any
+	err = s.modify(ctx, uid, func(data map[string]any) error {
+		old = make(map[string]any, len(data))
+		for k, v := range data {
+			old[k] = v
+		}
+		diff = restoreDiff(data, backup, opts)
+		for _, k := range append(diff.Added, diff.Changed...) {
+			data[k] = backup[k]
+		}
+		for _, k := range diff.Removed {
+			delete(data, k)
+		}
+		all = data
+		return nil
+	})
 	if err != nil {
-		log.Println("Cannot put object:", err)
+		return
+	}
+	s.record(ctx, "restore", uid, "", hashMap(old), hashMap(all))
+	s.recordHistory(ctx, "restore", uid, old, all)
+	return
+}
+
+// work out which keys restoring the backup over the data changes
+func restoreDiff(data map[string]any, backup map[string]any, opts RestoreOptions) (diff BackupDiff) {
+	keys := opts.Keys
+	if len(keys) == 0 {
+		for k := range data {
+			keys = append(keys, k)
+		}
+		for k := range backup {
+			if _, ok := data[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range unique(keys...) {
+		current, inData := data[k]
+		value, inBackup := backup[k]
+		switch {
+		case inBackup && !inData:
+			diff.Added = append(diff.Added, k)
+		case inData && !inBackup:
+			if !opts.Merge {
+				diff.Removed = append(diff.Removed, k)
+			}
+		case inData && !reflect.DeepEqual(current, value):
+			diff.Changed = append(diff.Changed, k)
+		}
 	}
 	return
 }
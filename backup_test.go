@@ -2,6 +2,8 @@ package gost
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -80,3 +82,319 @@ func TestBackupAndRestore(t *testing.T) {
 	}
 
 }
+
+func TestBackupGenerations(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	// start without the generations left by earlier runs
+	err = store.PurgeUser(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	err = store.Put(ctx, "generations-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Put(ctx, "generations-test", "b", "second")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	// nothing changed, so there should be no new generation
+	err = store.Backup(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	err = store.Delete(ctx, "generations-test", "a")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+	err = store.Put(ctx, "generations-test", "c", "third")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+
+	gens, err := store.Generations(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to list generations: %v", err)
+	}
+	if len(gens) != 2 || !gens[0].Full || gens[1].Full {
+		t.Fatalf("Wrong generations: %+v", gens)
+	}
+	if gens[1].Size >= gens[0].Size {
+		t.Errorf("Diff should be smaller than the full backup: %+v", gens)
+	}
+
+	data, err := store.Load(ctx, "generations-test")
+	if err != nil {
+		t.Errorf("Failed to load: %v", err)
+	}
+	if len(data) != 2 || data["b"] != "second" || data["c"] != "third" {
+		t.Errorf("Failed to replay the diff: %v", data)
+	}
+	data, err = store.LoadGeneration(ctx, "generations-test", 1)
+	if err != nil {
+		t.Errorf("Failed to load: %v", err)
+	}
+	if len(data) != 2 || data["a"] != "first" || data["b"] != "second" {
+		t.Errorf("Failed to load the first generation: %v", data)
+	}
+	_, err = store.LoadGeneration(ctx, "generations-test", 3)
+	if err != ErrNoSuchGeneration {
+		t.Errorf("Loading a missing generation should fail: %v", err)
+	}
+}
+
+func TestBackupMapUnchanged(t *testing.T) {
+	setup()
+	Register(map[string]any{})
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	// start without the generations left by earlier runs
+	err = store.PurgeUser(ctx, "map-backup-test")
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	err = store.Put(ctx, "map-backup-test", "settings", map[string]any{"theme": "dark", "lang": "en", "size": 12, "font": "serif"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	// gob encodes the map in a different order each time, which isn't a change
+	for i := 0; i < 2; i++ {
+		err = store.Backup(ctx, "map-backup-test")
+		if err != nil {
+			t.Errorf("Failed to backup: %v", err)
+		}
+	}
+	gens, err := store.Generations(ctx, "map-backup-test")
+	if err != nil {
+		t.Errorf("Failed to list generations: %v", err)
+	}
+	if len(gens) != 1 {
+		t.Errorf("Unchanged map should not make a new generation: %+v", gens)
+	}
+}
+
+func TestBackupStore(t *testing.T) {
+	setup()
+	backups, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-backups")
+	if err != nil {
+		t.Errorf("Failed to create backup store: %v", err)
+	}
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithBackupStore(backups))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "backup-store-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, "backup-store-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	// the backup should be in the backup store, not the store's own bucket
+	gens, err := backups.generations(ctx, "backup-store-test")
+	if err != nil {
+		t.Errorf("Failed to list generations: %v", err)
+	}
+	if len(gens) != 1 {
+		t.Errorf("Failed to get the right number of generations in the backup store: %d", len(gens))
+	}
+	gens, err = store.generations(ctx, "backup-store-test")
+	if err != nil {
+		t.Errorf("Failed to list generations: %v", err)
+	}
+	if len(gens) != 0 {
+		t.Errorf("Backup should not be in the store's own bucket: %v", gens)
+	}
+
+	err = store.Put(ctx, "backup-store-test", "a", "second")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Restore(ctx, "backup-store-test")
+	if err != nil {
+		t.Errorf("Failed to restore: %v", err)
+	}
+	value, err := store.Get(ctx, "backup-store-test", "a")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "first" {
+		t.Errorf("Failed to restore from the backup store: %v", value)
+	}
+}
+
+func TestRestoreWithoutBackup(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.PurgeUser(ctx, "no-backup-test")
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	err = store.Put(ctx, "no-backup-test", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Restore(ctx, "no-backup-test")
+	if !errors.Is(err, ErrNoSuchGeneration) {
+		t.Errorf("Restore without a backup should fail: %v", err)
+	}
+	data, err := store.Get(ctx, "no-backup-test", "123")
+	if err != nil || data != "hello world!" {
+		t.Errorf("Data should be left as it is: %v, %v", data, err)
+	}
+	loaded, err := store.Load(ctx, "no-backup-test")
+	if err != nil || len(loaded) != 0 {
+		t.Errorf("Load without a backup should be empty: %v, %v", loaded, err)
+	}
+}
+
+func TestBackupToAndRestoreFrom(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	other, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-other")
+	if err != nil {
+		t.Errorf("Failed to create other store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "backup-to-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.BackupTo(ctx, "backup-to-test", other)
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	err = store.Put(ctx, "backup-to-test", "a", "second")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.RestoreFrom(ctx, "backup-to-test", other)
+	if err != nil {
+		t.Errorf("Failed to restore: %v", err)
+	}
+	value, err := store.Get(ctx, "backup-to-test", "a")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "first" {
+		t.Errorf("Failed to restore from the other store: %v", value)
+	}
+}
+
+func TestDiffBackupAndRestoreWith(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	for k, v := range map[string]string{"a": "first", "b": "second", "c": "third"} {
+		err = store.Put(ctx, "restore-with-test", k, v)
+		if err != nil {
+			t.Errorf("Failed to store: %v", err)
+		}
+	}
+	// a map isn't changed just because gob encodes it in a different order
+	Register(map[string]any{})
+	settings := map[string]any{"theme": "dark", "lang": "en", "size": 12, "font": "serif"}
+	err = store.Put(ctx, "restore-with-test", "e", settings)
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, "restore-with-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	err = store.Delete(ctx, "restore-with-test", "a")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+	err = store.Put(ctx, "restore-with-test", "b", "changed")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Put(ctx, "restore-with-test", "d", "new")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+
+	diff, err := store.DiffBackup(ctx, "restore-with-test")
+	if err != nil {
+		t.Errorf("Failed to diff: %v", err)
+	}
+	if !reflect.DeepEqual(diff, BackupDiff{Added: []string{"a"}, Removed: []string{"d"}, Changed: []string{"b"}}) {
+		t.Errorf("Failed to get the right diff: %+v", diff)
+	}
+	// the diff shouldn't change anything
+	value, err := store.Get(ctx, "restore-with-test", "b")
+	if err != nil || value != "changed" {
+		t.Errorf("Diff should not change the data: %v, %v", value, err)
+	}
+
+	// restore only b
+	diff, err = store.RestoreWith(ctx, "restore-with-test", RestoreOptions{Keys: []string{"b"}})
+	if err != nil {
+		t.Errorf("Failed to restore: %v", err)
+	}
+	if !reflect.DeepEqual(diff, BackupDiff{Changed: []string{"b"}}) {
+		t.Errorf("Failed to get the right diff: %+v", diff)
+	}
+	all, err := store.GetAll(ctx, "restore-with-test")
+	if err != nil {
+		t.Errorf("Failed to get all: %v", err)
+	}
+	if !reflect.DeepEqual(all, map[string]any{"b": "second", "c": "third", "d": "new", "e": settings}) {
+		t.Errorf("Failed to restore only b: %v", all)
+	}
+
+	// merge keeps d
+	_, err = store.RestoreWith(ctx, "restore-with-test", RestoreOptions{Merge: true})
+	if err != nil {
+		t.Errorf("Failed to restore: %v", err)
+	}
+	all, err = store.GetAll(ctx, "restore-with-test")
+	if err != nil {
+		t.Errorf("Failed to get all: %v", err)
+	}
+	if !reflect.DeepEqual(all, map[string]any{"a": "first", "b": "second", "c": "third", "d": "new", "e": settings}) {
+		t.Errorf("Failed to merge: %v", all)
+	}
+}
+
+func TestBackupUID(t *testing.T) {
+	// "???" has a / in its legacy encoding
+	for _, objectName := range []string{backup("???"), legacyBackup("???"), generationName("???", 3, false)} {
+		uid, err := backupUID(objectName)
+		if err != nil || uid != "???" {
+			t.Errorf("Failed to get the unique ID of %v: %v, %v", objectName, uid, err)
+		}
+	}
+	if isGeneration(legacyBackup("???")) || !isGeneration(generationName("???", 3, true)) {
+		t.Errorf("Failed to tell legacy backups from generations")
+	}
+}
@@ -0,0 +1,426 @@
+package gost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// the prefix of the objects that hold backup manifests and checkpoints
+const manifestsPrefix = gostPrefix + "manifests/"
+
+// the number of unique IDs done between checkpoints
+const checkpointEvery = 100
+
+// ErrNoManifest is returned by RestoreAll when there is no backup manifest to restore from
+var ErrNoManifest = errors.New("gost: no backup manifest")
+
+// BulkOptions configures BackupAll and RestoreAll
+type BulkOptions struct {
+	// The number of unique IDs worked on at the same time, 4 if it's zero
+	Concurrency int
+	// Called after each unique ID is done, if it's not nil
+	Progress func(BulkProgress)
+	// Names the job so it can be resumed if it's interrupted. Progress is
+	// saved under this name as the job goes along, and a job started with the
+	// same name carries on from where the last one stopped. If it's empty,
+	// the job always starts from the beginning
+	Checkpoint string
+	// The manifest RestoreAll restores from, the latest one if it's empty
+	Manifest string
+	// The store backups are written to and restored from, with the manifests
+	// and checkpoints. If it's nil, backups are kept with the store's other
+	// backups
+	Store *Store
+}
+
+// get the store the backups of a bulk job are kept in
+func (s *Store) bulkStore(opts BulkOptions) *Store {
+	if opts.Store != nil {
+		return opts.Store
+	}
+	return s.backups()
+}
+
+// BulkProgress reports how far BackupAll or RestoreAll has got
+type BulkProgress struct {
+	// the unique ID that was just done, and the error if it failed
+	UID    string
+	Err    error
+	Done   int
+	Failed int
+	Total  int
+}
+
+// BackupManifest lists the backup generation of every unique ID taken by BackupAll
+// It's stored as JSON under gost/manifests/backup/, and RestoreAll uses it to
+// restore every unique ID to the same point
+type BackupManifest struct {
+	// the name of the object holding the manifest
+	ID       string
+	Started  time.Time
+	Finished time.Time
+	Backups  []ManifestBackup
+	Failures []ManifestFailure `json:",omitempty"`
+}
+
+// ManifestBackup is the backup generation of a unique ID in a manifest
+type ManifestBackup struct {
+	UID        string
+	Generation int
+	// the ETag of the generation, to check it hasn't changed since
+	ETag string
+}
+
+// ManifestFailure is a unique ID that couldn't be backed up or restored
+type ManifestFailure struct {
+	UID   string
+	Error string
+}
+
+// the state of an interrupted job, saved so it can be resumed
+type checkpoint struct {
+	Op       string
+	Manifest BackupManifest
+	// for RestoreAll, the unique IDs restored so far
+	Restored []string `json:",omitempty"`
+}
+
+// get the name of the object holding a checkpoint
+func checkpointObject(name string) string {
+	return manifestsPrefix + "checkpoints/" + encode(name) + ".json"
+}
+
+// BackupAll backs up the data for every unique ID, and writes a manifest
+// listing the backup generation of each of them
+// Unique IDs that fail to back up are listed in the manifest's Failures, and
+// the other unique IDs carry on being backed up. The error is only set if
+// the job itself fails, like when the context is cancelled
+func (s *Store) BackupAll(ctx context.Context, opts BulkOptions) (manifest BackupManifest, err error) {
+	ctx, end := s.longOperation(ctx, "BackupAll")
+	defer end(&err)
+	target := s.bulkStore(opts)
+	var saved checkpoint
+	if opts.Checkpoint != "" {
+		saved, err = target.readCheckpoint(ctx, opts.Checkpoint, "backup")
+		if err != nil {
+			return
+		}
+	}
+	manifest = saved.Manifest
+	if manifest.Started.IsZero() {
+		manifest.Started = time.Now().UTC()
+	}
+	done := make(map[string]bool)
+	for _, b := range manifest.Backups {
+		done[b.UID] = true
+	}
+	// failed unique IDs are tried again when the job is resumed
+	manifest.Failures = nil
+
+	uids, err := s.UIDs(ctx)
+	if err != nil {
+		return
+	}
+	var mutex sync.Mutex
+	err = s.bulk(ctx, uids, done, opts, func(uid string) (err error) {
+		gen, etag, err := s.backupTo(ctx, uid, target)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			manifest.Failures = append(manifest.Failures, ManifestFailure{uid, err.Error()})
+			return
+		}
+		manifest.Backups = append(manifest.Backups, ManifestBackup{uid, gen.Generation, etag})
+		return
+	}, func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return target.writeCheckpoint(ctx, opts.Checkpoint, checkpoint{Op: "backup", Manifest: manifest})
+	})
+	if err != nil {
+		return
+	}
+
+	sort.Slice(manifest.Backups, func(i, j int) bool { return manifest.Backups[i].UID < manifest.Backups[j].UID })
+	sort.Slice(manifest.Failures, func(i, j int) bool { return manifest.Failures[i].UID < manifest.Failures[j].UID })
+	manifest.Finished = time.Now().UTC()
+	manifest.ID = manifestsPrefix + "backup/" + manifest.Started.Format(auditTimeFormat) + ".json"
+	err = target.writeJSON(ctx, manifest.ID, manifest)
+	if err != nil {
+		return
+	}
+	err = target.removeCheckpoint(ctx, opts.Checkpoint)
+	return
+}
+
+// RestoreAll restores the data for every unique ID in a backup manifest, to
+// the backup generation listed for it
+// A unique ID is not restored if its generation has changed since the
+// manifest was written. Unique IDs that fail to restore are listed in the
+// returned manifest's Failures, and the other unique IDs carry on being
+// restored. The error is only set if the job itself fails
+func (s *Store) RestoreAll(ctx context.Context, opts BulkOptions) (manifest BackupManifest, err error) {
+	ctx, end := s.longOperation(ctx, "RestoreAll")
+	defer end(&err)
+	source := s.bulkStore(opts)
+	var saved checkpoint
+	if opts.Checkpoint != "" {
+		saved, err = source.readCheckpoint(ctx, opts.Checkpoint, "restore")
+		if err != nil {
+			return
+		}
+	}
+	manifest = saved.Manifest
+	if manifest.ID == "" {
+		manifest, err = source.readManifest(ctx, opts.Manifest)
+		if err != nil {
+			return
+		}
+	}
+	manifest.Failures = nil
+	done := make(map[string]bool)
+	for _, uid := range saved.Restored {
+		done[uid] = true
+	}
+	generations := make(map[string]ManifestBackup, len(manifest.Backups))
+	uids := make([]string, 0, len(manifest.Backups))
+	for _, b := range manifest.Backups {
+		generations[b.UID] = b
+		uids = append(uids, b.UID)
+	}
+
+	restored := saved.Restored
+	var mutex sync.Mutex
+	err = s.bulk(ctx, uids, done, opts, func(uid string) (err error) {
+		err = s.restoreManifestBackup(ctx, source, generations[uid])
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			manifest.Failures = append(manifest.Failures, ManifestFailure{uid, err.Error()})
+			return
+		}
+		restored = append(restored, uid)
+		return
+	}, func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return source.writeCheckpoint(ctx, opts.Checkpoint, checkpoint{Op: "restore", Manifest: manifest, Restored: restored})
+	})
+	if err != nil {
+		return
+	}
+	sort.Slice(manifest.Failures, func(i, j int) bool { return manifest.Failures[i].UID < manifest.Failures[j].UID })
+	err = source.removeCheckpoint(ctx, opts.Checkpoint)
+	return
+}
+
+// restore a unique ID to the generation in a manifest, from the source
+// store, if it hasn't changed since
+func (s *Store) restoreManifestBackup(ctx context.Context, source *Store, b ManifestBackup) (err error) {
+	gens, err := source.generations(ctx, b.UID)
+	if err != nil {
+		return
+	}
+	for _, g := range gens {
+		if g.Generation != b.Generation {
+			continue
+		}
+		var info minio.ObjectInfo
+		info, err = source.statObject(ctx, g.Object)
+		if err != nil {
+			log.Println("Cannot check backup:", err)
+			return
+		}
+		if info.ETag != b.ETag {
+			return fmt.Errorf("gost: backup generation %d of %q has changed since the manifest was written", b.Generation, b.UID)
+		}
+		_, err = s.restoreFrom(ctx, b.UID, source, b.Generation, RestoreOptions{})
+		return
+	}
+	return ErrNoSuchGeneration
+}
+
+// run fn for every unique ID that isn't done, with bounded concurrency,
+// reporting progress and saving a checkpoint every so often
+func (s *Store) bulk(ctx context.Context, uids []string, done map[string]bool, opts BulkOptions, fn func(uid string) error, save func(ctx context.Context) error) (err error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	progress := BulkProgress{Total: len(uids), Done: len(done)}
+	var mutex sync.Mutex
+	var saveErr error
+	report := func(uid string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		progress.UID, progress.Err = uid, err
+		progress.Done++
+		if err != nil {
+			progress.Failed++
+		}
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if opts.Checkpoint != "" && progress.Done%checkpointEvery == 0 && saveErr == nil {
+			saveErr = save(ctx)
+		}
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uid := range work {
+				report(uid, fn(uid))
+			}
+		}()
+	}
+	for _, uid := range uids {
+		if done[uid] {
+			continue
+		}
+		select {
+		case work <- uid:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+	close(work)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil && opts.Checkpoint != "" {
+		// save what was done so far, so the job can be resumed, even though
+		// the context may have been cancelled
+		saveCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if saveErr := save(saveCtx); saveErr != nil {
+			log.Println("Cannot save checkpoint:", saveErr)
+		}
+		return
+	}
+	if err == nil {
+		err = saveErr
+	}
+	return
+}
+
+// Manifests lists the names of the backup manifests written by BackupAll, oldest first
+func (s *Store) Manifests(ctx context.Context) (ids []string, err error) {
+	ctx, done := s.operation(ctx, "Manifests")
+	defer done(&err)
+	return s.backups().manifests(ctx)
+}
+
+// list the names of the backup manifests in this store
+func (s *Store) manifests(ctx context.Context) (ids []string, err error) {
+	for info := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: manifestsPrefix + "backup/", Recursive: true}) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list manifests:", err)
+			return
+		}
+		ids = append(ids, info.Key)
+	}
+	return
+}
+
+// read a backup manifest, or the latest one if id is empty
+func (s *Store) readManifest(ctx context.Context, id string) (manifest BackupManifest, err error) {
+	if id == "" {
+		var ids []string
+		ids, err = s.manifests(ctx)
+		if err != nil {
+			return
+		}
+		if len(ids) == 0 {
+			err = ErrNoManifest
+			return
+		}
+		id = ids[len(ids)-1]
+	}
+	found, err := s.readJSON(ctx, id, &manifest)
+	if err == nil && !found {
+		err = ErrNoManifest
+	}
+	return
+}
+
+// read the checkpoint of an interrupted job, which is empty if there isn't one
+func (s *Store) readCheckpoint(ctx context.Context, name string, op string) (saved checkpoint, err error) {
+	found, err := s.readJSON(ctx, checkpointObject(name), &saved)
+	if err == nil && found && saved.Op != op {
+		err = fmt.Errorf("gost: checkpoint %q is for %s, not %s", name, saved.Op, op)
+	}
+	return
+}
+
+// save the checkpoint of a job, if it has a name
+func (s *Store) writeCheckpoint(ctx context.Context, name string, saved checkpoint) (err error) {
+	if name == "" {
+		return
+	}
+	return s.writeJSON(ctx, checkpointObject(name), saved)
+}
+
+// remove the checkpoint of a finished job, if it has a name
+func (s *Store) removeCheckpoint(ctx context.Context, name string) (err error) {
+	if name == "" {
+		return
+	}
+	err = s.removeObject(ctx, checkpointObject(name))
+	if err != nil {
+		log.Println("Cannot remove checkpoint:", err)
+	}
+	return
+}
+
+// write a value into an object as JSON
+func (s *Store) writeJSON(ctx context.Context, objectName string, v any) (err error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = s.upload(ctx, objectName, b, minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		log.Println("Cannot put object:", err)
+	}
+	return
+}
+
+// read a value from an object holding JSON, found is false if the object doesn't exist
+func (s *Store) readJSON(ctx context.Context, objectName string, v any) (found bool, err error) {
+	obj, err := s.openObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	defer obj.Close()
+	b, err := io.ReadAll(obj)
+	if isNoSuchKey(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("Cannot read object:", err)
+		return
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		log.Println("Cannot decode object:", err)
+	}
+	return err == nil, err
+}
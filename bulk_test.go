@@ -0,0 +1,80 @@
+package gost
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBackupAllAndRestoreAll(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	Register(Thingy{})
+	ctx := context.Background()
+	for _, uid := range []string{"bulk-test-1", "bulk-test-2"} {
+		err = store.Put(ctx, uid, "plan", "free")
+		if err != nil {
+			t.Errorf("Failed to store: %v", err)
+		}
+	}
+
+	// stop after the first unique ID, then resume from the checkpoint
+	cancelled, cancel := context.WithCancel(ctx)
+	_, err = store.BackupAll(cancelled, BulkOptions{
+		Concurrency: 1,
+		Checkpoint:  "bulk-test",
+		Progress: func(p BulkProgress) {
+			cancel()
+		},
+	})
+	if err != context.Canceled {
+		t.Errorf("Backup should be interrupted: %v", err)
+	}
+	resumedFrom := -1
+	manifest, err := store.BackupAll(ctx, BulkOptions{
+		Checkpoint: "bulk-test",
+		Progress: func(p BulkProgress) {
+			if resumedFrom < 0 {
+				resumedFrom = p.Done - 1
+			}
+		},
+	})
+	if err != nil {
+		t.Errorf("Failed to back up: %v", err)
+	}
+	if resumedFrom < 1 {
+		t.Errorf("Backup should resume from the checkpoint: %v", resumedFrom)
+	}
+	backedUp := make(map[string]bool)
+	for _, b := range manifest.Backups {
+		backedUp[b.UID] = b.ETag != ""
+	}
+	if !backedUp["bulk-test-1"] || !backedUp["bulk-test-2"] {
+		t.Errorf("Manifest should list every unique ID: %+v", manifest)
+	}
+
+	for _, uid := range []string{"bulk-test-1", "bulk-test-2"} {
+		err = store.Put(ctx, uid, "plan", "pro")
+		if err != nil {
+			t.Errorf("Failed to store: %v", err)
+		}
+	}
+	restored, err := store.RestoreAll(ctx, BulkOptions{Manifest: manifest.ID})
+	if err != nil {
+		t.Errorf("Failed to restore: %v", err)
+	}
+	if len(restored.Failures) != 0 {
+		t.Errorf("Failed to restore: %+v", restored.Failures)
+	}
+	for _, uid := range []string{"bulk-test-1", "bulk-test-2"} {
+		plan, err := store.Get(ctx, uid, "plan")
+		if err != nil {
+			t.Errorf("Failed to get: %v", err)
+		}
+		if plan != "free" {
+			t.Errorf("Failed to restore %v: %v", uid, plan)
+		}
+	}
+}
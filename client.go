@@ -0,0 +1,150 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// open an object for reading, stopping with the context
+// The object is fetched straight away, so transient failures can be retried,
+// but other errors, like the object not existing, are left for the first Stat
+// or Read of the object to return. Ranges aren't fetched straight away,
+// because calling Stat on the object drops the range
+func (s *Store) openObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (obj objectReader, err error) {
+	err = s.call(ctx, "get object", func() (err error) {
+		o, err := s.client.GetObject(ctx, s.bucket, objectName, opts)
+		if err != nil {
+			return
+		}
+		if opts.Header().Get("Range") != "" {
+			obj = objectReader{o, ctx, s}
+			return
+		}
+		info, err := o.Stat()
+		if err != nil && s.retry.retryable(err) {
+			o.Close()
+			return
+		}
+		observed(ctx).sawCodec(info.UserMetadata)
+		obj = objectReader{o, ctx, s}
+		return nil
+	})
+	return
+}
+
+// get information about an object
+func (s *Store) statObject(ctx context.Context, objectName string) (info minio.ObjectInfo, err error) {
+	err = s.call(ctx, "stat object", func() (err error) {
+		info, err = s.client.StatObject(ctx, s.bucket, objectName, minio.StatObjectOptions{})
+		return
+	})
+	return
+}
+
+// write an object with the contents of body, with the conditions in the context
+// A conditional write that is sent again after it succeeded fails its own
+// condition, so it isn't retried. The minio client can still send it again
+// by itself, so each conditional write is tagged with an ID, and if it fails
+// its condition after being sent more than once, it succeeded if the object
+// has its ID
+func (s *Store) upload(ctx context.Context, objectName string, body []byte, opts minio.PutObjectOptions) (info minio.UploadInfo, err error) {
+	policy := s.retry
+	cond, conditional := conditionOf(ctx)
+	var writeID string
+	if conditional {
+		policy = RetryPolicy{MaxAttempts: 1}
+		writeID = newWriteID()
+		metadata := map[string]string{writeIDMeta: writeID}
+		for k, v := range opts.UserMetadata {
+			metadata[k] = v
+		}
+		opts.UserMetadata = metadata
+		// the whole body is here anyway, and sending it in one request means
+		// the condition is checked before anything is stored
+		opts.DisableMultipart = true
+	}
+	err = s.callWith(ctx, "put object", policy, func() (err error) {
+		info, err = s.client.PutObject(ctx, s.bucket, objectName, bytes.NewReader(body), int64(len(body)), opts)
+		return
+	})
+	if conditional && isPreconditionFailed(err) && atomic.LoadInt32(cond.sent) > 1 {
+		info, err = s.written(ctx, objectName, writeID, err)
+	}
+	if err == nil {
+		s.transferred(ctx, "write", int64(len(body)))
+		observed(ctx).sawCodec(opts.UserMetadata)
+	}
+	return
+}
+
+// user metadata with the ID of the conditional write that wrote an object
+const writeIDMeta = "Gost-Write-Id"
+
+// make an ID for a conditional write
+func newWriteID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// check if the object was written by the conditional write with the ID,
+// returning its info if it was, or the error the write failed with if not
+func (s *Store) written(ctx context.Context, objectName string, writeID string, writeErr error) (info minio.UploadInfo, err error) {
+	stat, err := s.statObject(ctx, objectName)
+	if err != nil || stat.UserMetadata[writeIDMeta] != writeID {
+		return info, writeErr
+	}
+	info = minio.UploadInfo{
+		Bucket:       s.bucket,
+		Key:          stat.Key,
+		ETag:         stat.ETag,
+		Size:         stat.Size,
+		LastModified: stat.LastModified,
+		VersionID:    stat.VersionID,
+	}
+	return
+}
+
+// remove an object
+func (s *Store) removeObject(ctx context.Context, objectName string) (err error) {
+	return s.call(ctx, "remove object", func() error {
+		return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
+	})
+}
+
+// list objects, carrying on from where the listing stopped if it fails with a
+// transient error
+// Like the minio client, a failure is sent as an object with Err set, after
+// which the channel is closed
+func (s *Store) listObjects(ctx context.Context, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		err := s.call(ctx, "list objects", func() error {
+			for info := range s.client.ListObjects(ctx, s.bucket, opts) {
+				if info.Err != nil {
+					return info.Err
+				}
+				opts.StartAfter = info.Key
+				select {
+				case objects <- info:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			select {
+			case objects <- minio.ObjectInfo{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return objects
+}
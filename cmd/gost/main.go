@@ -0,0 +1,374 @@
+// Command gost inspects and edits the data in a gost store
+//
+// The store is configured with the KEY, SECRET, ENDPOINT, USE_SSL, REGION and
+// BUCKET environment variables, which can also be set in a .env file in the
+// current directory.
+//
+// Usage:
+//
+//	gost <command> [arguments]
+//
+// Run gost with no arguments to see the list of commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/sausheong/gost"
+)
+
+// a command that can be run from the command line
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, store *gost.Store, args []string) error
+}
+
+var commands = map[string]command{
+	"get": {"get <uid> <key>", "print the data for a key", get},
+	"put": {"put [-json] [-file] <uid> <key> <value>",
+		"put a string, a JSON value (-json) or the contents of a file (-file) in a key", put},
+	"delete":       {"delete <uid> <key>", "delete a key", del},
+	"delete-all":   {"delete-all <uid>", "delete all the data for a unique ID", deleteAll},
+	"dump":         {"dump <uid>", "print all the data for a unique ID as JSON", dump},
+	"list":         {"list", "list the unique IDs with data", list},
+	"backup":       {"backup <uid>", "back up the data for a unique ID", backup},
+	"restore":      {"restore [-dry-run] [-merge] [-keys k,...] <uid>", "restore the data for a unique ID from its backup", restore},
+	"load":         {"load <uid>", "print the backup for a unique ID as JSON", load},
+	"publish":      {"publish [-type content-type] <file> [filename]", "publish a file", publish},
+	"unpublish":    {"unpublish <filename>", "unpublish a file", unpublish},
+	"allow-public": {"allow-public", "make published files publicly accessible", allowPublic},
+	"deny-public":  {"deny-public", "make published files private", denyPublic},
+	"is-public":    {"is-public", "check if published files are publicly accessible", isPublic},
+	"export":       {"export [file]", "export the whole store into a tar archive, written to stdout if no file is given", export},
+	"import": {"import [-mode merge|overwrite|skip] [file]",
+		"import a tar archive made by export, read from stdin if no file is given", importArchive},
+}
+
+// the order commands are listed in the usage message
+var order = []string{"get", "put", "delete", "delete-all", "dump", "list", "backup", "restore", "load",
+	"publish", "unpublish", "allow-public", "deny-public", "is-public", "export", "import"}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gost <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range order {
+		fmt.Fprintf(os.Stderr, "  %-48s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "The store is configured with the KEY, SECRET, ENDPOINT, USE_SSL, REGION and BUCKET environment variables.")
+}
+
+func init() {
+	// JSON objects and arrays put with -json
+	gost.Register(map[string]any{})
+	gost.Register([]any{})
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	store, err := open()
+	if err != nil {
+		log.Fatalln("Cannot open store:", err)
+	}
+	err = cmd.run(context.Background(), store, os.Args[2:])
+	if err == errUsage {
+		fmt.Fprintln(os.Stderr, "Usage: gost", cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// open the store configured in the environment
+func open() (store *gost.Store, err error) {
+	// the .env file is optional, the variables can be set in the environment
+	godotenv.Load()
+	useSSL := false
+	if os.Getenv("USE_SSL") != "" {
+		useSSL, err = strconv.ParseBool(os.Getenv("USE_SSL"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse USE_SSL: %w", err)
+		}
+	}
+	return gost.NewStoreContext(context.Background(), os.Getenv("KEY"), os.Getenv("SECRET"), os.Getenv("ENDPOINT"), useSSL,
+		os.Getenv("REGION"), os.Getenv("BUCKET"))
+}
+
+// returned by commands given the wrong arguments
+var errUsage = errors.New("wrong arguments")
+
+// check the number of arguments for a command
+func nargs(args []string, min int, max int) error {
+	if len(args) < min || len(args) > max {
+		return errUsage
+	}
+	return nil
+}
+
+func get(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 2, 2); err != nil {
+		return
+	}
+	data, err := store.Get(ctx, args[0], args[1])
+	if err != nil {
+		return
+	}
+	fmt.Println(pretty(data))
+	return
+}
+
+func put(ctx context.Context, store *gost.Store, args []string) (err error) {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "parse the value as JSON")
+	asFile := flags.Bool("file", false, "use the contents of the file named by the value")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	args = flags.Args()
+	if err = nargs(args, 3, 3); err != nil {
+		return
+	}
+	var data any = args[2]
+	switch {
+	case *asJSON && *asFile:
+		return fmt.Errorf("-json and -file can't be used together")
+	case *asJSON:
+		err = json.Unmarshal([]byte(args[2]), &data)
+	case *asFile:
+		data, err = os.ReadFile(args[2])
+	}
+	if err != nil {
+		return
+	}
+	return store.Put(ctx, args[0], args[1], data)
+}
+
+func del(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 2, 2); err != nil {
+		return
+	}
+	return store.Delete(ctx, args[0], args[1])
+}
+
+func deleteAll(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	return store.DeleteAll(ctx, args[0])
+}
+
+func dump(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	data, err := store.GetAll(ctx, args[0])
+	if err != nil {
+		return
+	}
+	fmt.Println(pretty(data))
+	return
+}
+
+func list(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 0, 0); err != nil {
+		return
+	}
+	uids, err := store.UIDs(ctx)
+	if err != nil {
+		return
+	}
+	for _, uid := range uids {
+		fmt.Println(uid)
+	}
+	return
+}
+
+func backup(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	return store.Backup(ctx, args[0])
+}
+
+func restore(ctx context.Context, store *gost.Store, args []string) (err error) {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print what would be restored without restoring it")
+	merge := flags.Bool("merge", false, "keep keys that aren't in the backup")
+	keys := flags.String("keys", "", "comma separated keys to restore, all of them if not given")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	args = flags.Args()
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	opts := gost.RestoreOptions{DryRun: *dryRun, Merge: *merge}
+	if *keys != "" {
+		opts.Keys = strings.Split(*keys, ",")
+	}
+	diff, err := store.RestoreWith(ctx, args[0], opts)
+	if err != nil {
+		return
+	}
+	for _, k := range diff.Added {
+		fmt.Println("+", k)
+	}
+	for _, k := range diff.Removed {
+		fmt.Println("-", k)
+	}
+	for _, k := range diff.Changed {
+		fmt.Println("~", k)
+	}
+	return
+}
+
+func load(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	data, err := store.Load(ctx, args[0])
+	if err != nil {
+		return
+	}
+	fmt.Println(pretty(data))
+	return
+}
+
+func publish(ctx context.Context, store *gost.Store, args []string) (err error) {
+	flags := flag.NewFlagSet("publish", flag.ContinueOnError)
+	contentType := flags.String("type", "", "content type, guessed from the file extension if not given")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	args = flags.Args()
+	if err = nargs(args, 1, 2); err != nil {
+		return
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return
+	}
+	filename := filepath.Base(args[0])
+	if len(args) == 2 {
+		filename = args[1]
+	}
+	if *contentType == "" {
+		*contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if *contentType == "" {
+		*contentType = "application/octet-stream"
+	}
+	_, location, err := store.Publish(ctx, filename, *contentType, data)
+	if err != nil {
+		return
+	}
+	fmt.Println(location)
+	return
+}
+
+func unpublish(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 1, 1); err != nil {
+		return
+	}
+	return store.Unpublish(ctx, args[0])
+}
+
+func allowPublic(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 0, 0); err != nil {
+		return
+	}
+	return store.AllowPublic(ctx)
+}
+
+func denyPublic(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 0, 0); err != nil {
+		return
+	}
+	return store.DenyPublic(ctx)
+}
+
+func isPublic(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 0, 0); err != nil {
+		return
+	}
+	public, err := store.IsPublic(ctx)
+	if err != nil {
+		return
+	}
+	fmt.Println(public)
+	return
+}
+
+func export(ctx context.Context, store *gost.Store, args []string) (err error) {
+	if err = nargs(args, 0, 1); err != nil {
+		return
+	}
+	w := os.Stdout
+	if len(args) == 1 {
+		w, err = os.Create(args[0])
+		if err != nil {
+			return
+		}
+		defer w.Close()
+	}
+	n, err := store.Export(ctx, w)
+	if err != nil {
+		return
+	}
+	log.Printf("Exported %d objects", n)
+	return
+}
+
+func importArchive(ctx context.Context, store *gost.Store, args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	modeName := flags.String("mode", "merge", "what to do with objects already in the store: merge, overwrite or skip")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	args = flags.Args()
+	if err = nargs(args, 0, 1); err != nil {
+		return
+	}
+	mode, err := gost.ParseImportMode(*modeName)
+	if err != nil {
+		return
+	}
+	r := os.Stdin
+	if len(args) == 1 {
+		r, err = os.Open(args[0])
+		if err != nil {
+			return
+		}
+		defer r.Close()
+	}
+	n, err := store.Import(ctx, r, mode)
+	if err != nil {
+		return
+	}
+	log.Printf("Imported %d objects", n)
+	return
+}
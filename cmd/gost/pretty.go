@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// render a value decoded from a gob as readably as possible
+// Values are rendered as indented JSON where they can be, and with fmt where they can't
+func pretty(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(jsonable(v))
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// convert a value into one that can be marshalled into JSON
+// Byte slices are summarised, maps with keys JSON can't handle get their keys
+// formatted with fmt, and values JSON can't handle are formatted with fmt
+func jsonable(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if len(v) > 64 {
+			return fmt.Sprintf("<%d bytes>", len(v))
+		}
+		return fmt.Sprintf("%q", v)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = jsonable(e)
+		}
+		return m
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		m := make(map[string]any, rv.Len())
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			m[fmt.Sprint(k.Interface())] = jsonable(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		s := make([]any, rv.Len())
+		for i := range s {
+			s[i] = jsonable(rv.Index(i).Interface())
+		}
+		return s
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128:
+		return fmt.Sprintf("%+v", v)
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return v
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type thingy struct {
+	Name        string
+	Age         int
+	DateCreated time.Time
+	Scores      map[int]float64
+}
+
+func TestPretty(t *testing.T) {
+	if pretty("hello world!") != "hello world!" {
+		t.Errorf("Strings should be printed as-is")
+	}
+	out := pretty(map[string]any{
+		"Bob":   thingy{Name: "Bob", Age: 42, Scores: map[int]float64{1: 1.5}},
+		"image": make([]byte, 1000),
+		"short": []byte("hi"),
+	})
+	for _, want := range []string{`"Name": "Bob"`, `"Age": 42`, `"<1000 bytes>"`, `"\"hi\""`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Failed to find %s in:\n%s", want, out)
+		}
+	}
+	out = pretty(map[float64]complex128{1.5: 1 + 2i})
+	if !strings.Contains(out, `"1.5": "(1+2i)"`) {
+		t.Errorf("Failed to render values JSON can't handle:\n%s", out)
+	}
+}
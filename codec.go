@@ -0,0 +1,197 @@
+package gost
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+)
+
+// The version of the format data is stored in
+// Version 1 is a gob of a map[string]any for data, and a gob of an any for
+// objects. Version 2 puts each value in an envelope with the name and schema
+// version of its type
+const codecVersion = 2
+
+// user metadata with the version of the format an object is stored in
+const codecMeta = "Gost-Codec"
+
+// the metadata written with every map and object encoded by gost
+func codecMetadata() map[string]string {
+	return map[string]string{codecMeta: strconv.Itoa(codecVersion)}
+}
+
+// a stored value, with the name and schema version of its type
+type envelope struct {
+	// The name the type is registered with, empty if the type isn't registered
+	// with gost, in which case the data is the gob of the value as an interface
+	Type    string
+	Version int
+	Data    []byte
+}
+
+// the format maps of data are stored in
+type storedMap struct {
+	Codec  int
+	Values map[string]envelope
+}
+
+// the format objects are stored in
+type storedObject struct {
+	Codec int
+	Value envelope
+}
+
+// put a value in an envelope
+func encodeValue(v any) (e envelope, err error) {
+	if v == nil {
+		return
+	}
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if tv, ok := lookupType(reflect.TypeOf(v)); ok {
+		e.Type, e.Version = tv.name, tv.version
+		err = enc.EncodeValue(reflect.ValueOf(v))
+	} else {
+		// types that aren't registered with gost, like built-in types, are
+		// encoded as interfaces the way they were before envelopes
+		err = enc.Encode(&v)
+	}
+	e.Data = buf.Bytes()
+	return
+}
+
+// take a value out of its envelope, upgrading it to the newest version of its type
+func decodeValue(e envelope) (v any, migrated bool, err error) {
+	if e.Type == "" {
+		if len(e.Data) > 0 {
+			err = gobError(gob.NewDecoder(bytes.NewReader(e.Data)).Decode(&v))
+		}
+	} else {
+		rt, ok := lookupName(e.Type, e.Version)
+		if !ok {
+			err = (*UnregisteredTypeError)(nil).add(unregisteredName(e.Type, e.Version))
+			return
+		}
+		ptr := reflect.New(rt)
+		err = gob.NewDecoder(bytes.NewReader(e.Data)).DecodeValue(ptr)
+		v = ptr.Elem().Interface()
+	}
+	if err != nil {
+		return
+	}
+	return migrate(v)
+}
+
+// decode a map of data
+// The map is stale if it's stored in an older format or if any values were
+// upgraded to newer versions of their types, so it should be written again
+func decodeMap(r io.Reader) (data map[string]any, stale bool, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		log.Println("Cannot read data:", err)
+		return
+	}
+	var stored storedMap
+	if gob.NewDecoder(bytes.NewReader(b)).Decode(&stored) != nil || stored.Codec == 0 {
+		// stored before values were put in envelopes
+		stale = true
+		err = gobError(gob.NewDecoder(bytes.NewReader(b)).Decode(&data))
+		if err != nil {
+			log.Println("Cannot decode data:", err)
+			return
+		}
+		for k, v := range data {
+			data[k], _, err = migrate(v)
+			if err != nil {
+				log.Println("Cannot migrate data:", err)
+				return
+			}
+		}
+		return
+	}
+	data = make(map[string]any, len(stored.Values))
+	// carry on past unregistered types so the error lists all of them
+	var unregistered *UnregisteredTypeError
+	for k, e := range stored.Values {
+		var migrated bool
+		data[k], migrated, err = decodeValue(e)
+		if u, ok := err.(*UnregisteredTypeError); ok {
+			unregistered = unregistered.add(u.Types...)
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot decode data:", err)
+			return
+		}
+		stale = stale || migrated
+	}
+	if unregistered != nil {
+		err = unregistered
+		log.Println("Cannot decode data:", err)
+	}
+	return
+}
+
+// encode a map of data
+func encodeMap(data map[string]any) (buf *bytes.Buffer, err error) {
+	stored := storedMap{Codec: codecVersion, Values: make(map[string]envelope, len(data))}
+	for k, v := range data {
+		stored.Values[k], err = encodeValue(v)
+		if err != nil {
+			log.Println("Cannot encode gob:", err)
+			return
+		}
+	}
+	buf = new(bytes.Buffer)
+	err = gob.NewEncoder(buf).Encode(stored)
+	if err != nil {
+		log.Println("Cannot encode gob:", err)
+	}
+	return
+}
+
+// decode an object
+// The object is stale if it's stored in an older format or if it was
+// upgraded to a newer version of its type
+func decodeObject(r io.Reader) (obj any, stale bool, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		log.Println("Cannot read object:", err)
+		return
+	}
+	var stored storedObject
+	if gob.NewDecoder(bytes.NewReader(b)).Decode(&stored) != nil || stored.Codec == 0 {
+		// stored before values were put in envelopes
+		err = gobError(gob.NewDecoder(bytes.NewReader(b)).Decode(&obj))
+		if err != nil {
+			log.Println("Cannot decode object:", err)
+			return
+		}
+		obj, _, err = migrate(obj)
+		return obj, true, err
+	}
+	obj, stale, err = decodeValue(stored.Value)
+	if err != nil {
+		log.Println("Cannot decode object:", err)
+	}
+	return
+}
+
+// encode an object
+func encodeObject(obj any) (buf *bytes.Buffer, err error) {
+	stored := storedObject{Codec: codecVersion}
+	stored.Value, err = encodeValue(obj)
+	if err != nil {
+		log.Println("Cannot encode gob:", err)
+		return
+	}
+	buf = new(bytes.Buffer)
+	err = gob.NewEncoder(buf).Encode(stored)
+	if err != nil {
+		log.Println("Cannot encode gob:", err)
+	}
+	return
+}
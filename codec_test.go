@@ -0,0 +1,254 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+type ProfileV1 struct {
+	Name string
+}
+
+type Profile struct {
+	FirstName string
+	LastName  string
+}
+
+func init() {
+	Migrate(func(old ProfileV1) (Profile, error) {
+		first, last, _ := strings.Cut(old.Name, " ")
+		return Profile{FirstName: first, LastName: last}, nil
+	})
+}
+
+func TestDecodeLegacyMap(t *testing.T) {
+	var buf bytes.Buffer
+	legacy := map[string]any{"profile": ProfileV1{Name: "Alice Tan"}, "123": "hello world!"}
+	err := gob.NewEncoder(&buf).Encode(legacy)
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	data, stale, err := decodeMap(&buf)
+	if err != nil {
+		t.Errorf("Failed to decode: %v", err)
+	}
+	if !stale {
+		t.Errorf("Legacy map should be stale")
+	}
+	if data["profile"] != (Profile{FirstName: "Alice", LastName: "Tan"}) {
+		t.Errorf("Failed to migrate the profile: %v", data["profile"])
+	}
+	if data["123"] != "hello world!" {
+		t.Errorf("Failed to get the right thing: %v", data["123"])
+	}
+}
+
+func TestDecodeMigratedMap(t *testing.T) {
+	buf, err := encodeMap(map[string]any{"profile": ProfileV1{Name: "Alice Tan"}})
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	data, stale, err := decodeMap(buf)
+	if err != nil {
+		t.Errorf("Failed to decode: %v", err)
+	}
+	if !stale {
+		t.Errorf("Map with an old version should be stale")
+	}
+	if data["profile"] != (Profile{FirstName: "Alice", LastName: "Tan"}) {
+		t.Errorf("Failed to migrate the profile: %v", data["profile"])
+	}
+
+	buf, err = encodeMap(data)
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	_, stale, err = decodeMap(buf)
+	if err != nil {
+		t.Errorf("Failed to decode: %v", err)
+	}
+	if stale {
+		t.Errorf("Map with the newest version should not be stale")
+	}
+}
+
+func TestMigrateAll(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "migrate-test", "profile", ProfileV1{Name: "Alice Tan"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.PutObject(ctx, "migrate-test-profile", ProfileV1{Name: "Bob Lim"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	stats, err := store.MigrateAll(ctx)
+	if err != nil {
+		t.Errorf("Failed to migrate: %v", err)
+	}
+	if stats.Migrated < 2 {
+		t.Errorf("Failed to migrate the stale data: %+v", stats)
+	}
+	stats, err = store.MigrateAll(ctx)
+	if err != nil {
+		t.Errorf("Failed to migrate: %v", err)
+	}
+	if stats.Migrated != 0 {
+		t.Errorf("Migrated data should not be migrated again: %+v", stats)
+	}
+	obj, err := store.GetObject(ctx, "migrate-test-profile")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if obj != (Profile{FirstName: "Bob", LastName: "Lim"}) {
+		t.Errorf("Failed to migrate the profile: %v", obj)
+	}
+}
+
+type Renamed struct {
+	Name string
+}
+
+func TestRegisterName(t *testing.T) {
+	RegisterName("gost-test.Renamed", Renamed{})
+	RegisterAlias("old-package.Renamed", Renamed{})
+	e, err := encodeValue(Renamed{Name: "Alice"})
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	if e.Type != "gost-test.Renamed" {
+		t.Errorf("Failed to store with the registered name: %v", e.Type)
+	}
+	e.Type = "old-package.Renamed"
+	v, _, err := decodeValue(e)
+	if err != nil {
+		t.Errorf("Failed to decode with an alias: %v", err)
+	}
+	if v != (Renamed{Name: "Alice"}) {
+		t.Errorf("Failed to get the right thing: %v", v)
+	}
+}
+
+type GobNamed struct{ Name string }
+
+type GobClash struct{ Name string }
+
+type GobOther struct{ Name string }
+
+func TestRegisterWithGob(t *testing.T) {
+	// a type gob already knows by another name is fine
+	gob.RegisterName("gost-test.GobNamed", GobNamed{})
+	Register(GobNamed{})
+	// another type registered with gob under the name isn't
+	gob.RegisterName(defaultName(reflect.TypeOf(GobClash{})), GobOther{})
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering a type whose name gob has for another type should panic")
+		}
+	}()
+	Register(GobClash{})
+}
+
+func TestUnregisteredTypes(t *testing.T) {
+	stored := storedMap{Codec: codecVersion, Values: map[string]envelope{
+		"a": {Type: "gost-test.Missing", Version: 1},
+		"b": {Type: "gost-test.Gone", Version: 1},
+		"c": {Type: "gost-test.Missing", Version: 1},
+	}}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(stored)
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	_, _, err = decodeMap(&buf)
+	var unregistered *UnregisteredTypeError
+	if !errors.As(err, &unregistered) {
+		t.Fatalf("Should fail with unregistered types: %v", err)
+	}
+	if strings.Join(unregistered.Types, ",") != "gost-test.Gone,gost-test.Missing" {
+		t.Errorf("Failed to list the unregistered types: %v", unregistered.Types)
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// pretend the data was stored by a program with a type this one doesn't have
+	stored := storedMap{Codec: codecVersion, Values: map[string]envelope{
+		"elsewhere": {Type: "gost-test.Elsewhere", Version: 1},
+	}}
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(stored)
+	if err != nil {
+		t.Errorf("Failed to encode: %v", err)
+	}
+	b := buf.Bytes()
+	_, err = store.client.PutObject(ctx, store.bucket, name("check-types-test"), bytes.NewReader(b), int64(len(b)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	defer store.client.RemoveObject(ctx, store.bucket, name("check-types-test"), minio.RemoveObjectOptions{})
+
+	err = store.CheckTypes(ctx)
+	var unregistered *UnregisteredTypeError
+	if !errors.As(err, &unregistered) {
+		t.Fatalf("Should fail with unregistered types: %v", err)
+	}
+	found := false
+	for _, name := range unregistered.Types {
+		found = found || name == "gost-test.Elsewhere"
+	}
+	if !found {
+		t.Errorf("Failed to list the unregistered type: %v", unregistered.Types)
+	}
+}
+
+func TestReadStoredObject(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// objects without the codec metadata are only read if they are small
+	for objectName, value := range map[string]string{
+		"stored-small": "a small object",
+		"stored-large": strings.Repeat("a", maxUnmarkedObjectSize),
+	} {
+		buf, err := encodeObject(value)
+		if err != nil {
+			t.Errorf("Failed to encode: %v", err)
+		}
+		_, err = store.client.PutObject(ctx, store.bucket, objectName, bytes.NewReader(buf.Bytes()), int64(buf.Len()),
+			minio.PutObjectOptions{ContentType: "application/octet-stream"})
+		if err != nil {
+			t.Errorf("Failed to store: %v", err)
+		}
+		defer store.client.RemoveObject(ctx, store.bucket, objectName, minio.RemoveObjectOptions{})
+	}
+	st, ok, err := store.readStoredObject(ctx, "stored-small")
+	if err != nil || !ok || st.obj != "a small object" {
+		t.Errorf("Small object should be read: %v, %v, %v", st.obj, ok, err)
+	}
+	_, ok, err = store.readStoredObject(ctx, "stored-large")
+	if err != nil || ok {
+		t.Errorf("Large object should not be read: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,235 @@
+package gost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// all raw objects in collections are stored under this prefix, so that they
+// can never collide with the data/, backup/ and public/ prefixes
+const collectionPrefix = "objects/"
+
+// Collection is a named group of raw objects, identified by keys
+type Collection struct {
+	store *Store
+	name  string
+}
+
+// Get a collection of raw objects with the given name
+// Collection names must be made of lower case letters, digits, '-', '_' and '.'
+func (s *Store) Collection(name string) *Collection {
+	return &Collection{store: s, name: name}
+}
+
+// Name of the collection
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// check that the collection name is valid
+func (c *Collection) validate() (err error) {
+	if c.name == "" || len(c.name) > 63 {
+		return &NameError{"collection", c.name, "must be 1 to 63 characters"}
+	}
+	for _, r := range c.name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return &NameError{"collection", c.name, fmt.Sprintf("must not contain %q", r)}
+		}
+	}
+	return
+}
+
+// check that the key is valid, and get the name of the object to store
+// Keys follow the same rules as object names, except that they can start with any prefix
+func (c *Collection) object(key string) (name string, err error) {
+	err = c.validate()
+	if err != nil {
+		return
+	}
+	err = validatePath("key", key)
+	if err != nil {
+		return
+	}
+	name = collectionPrefix + c.name + "/" + key
+	return
+}
+
+// Put an object in the collection, with the given key
+func (c *Collection) Put(ctx context.Context, key string, obj any, opts ...PutOptions) (err error) {
+	ctx, done := c.store.operation(ctx, "Collection.Put")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	return c.store.putObject(ctx, name, obj, opts...)
+}
+
+// Get an object from the collection
+func (c *Collection) Get(ctx context.Context, key string) (obj any, err error) {
+	ctx, done := c.store.operation(ctx, "Collection.Get")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	return c.store.getObject(ctx, name)
+}
+
+// Delete an object from the collection
+func (c *Collection) Delete(ctx context.Context, key string) (err error) {
+	ctx, done := c.store.operation(ctx, "Collection.Delete")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	return c.store.deleteObject(ctx, name)
+}
+
+// Put raw data from a reader into the collection, with the given key
+func (c *Collection) PutStream(ctx context.Context, key string, r io.Reader, opts PutOptions) (info ObjectInfo, err error) {
+	ctx, done := c.store.longOperation(ctx, "Collection.PutStream")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	info, err = c.store.putStream(ctx, name, r, opts)
+	info.Key = key
+	return
+}
+
+// Get raw data from the collection as a reader
+// The caller must close the reader when done
+func (c *Collection) GetStream(ctx context.Context, key string) (r io.ReadCloser, info ObjectInfo, err error) {
+	ctx, done := c.store.longOperation(ctx, "Collection.GetStream")
+	defer done(&err)
+	return c.GetRange(ctx, key, 0, 0)
+}
+
+// Get part of the raw data from the collection as a reader
+// The caller must close the reader when done
+func (c *Collection) GetRange(ctx context.Context, key string, offset int64, length int64) (r io.ReadCloser, info ObjectInfo, err error) {
+	ctx, done := c.store.longOperation(ctx, "Collection.GetRange")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	r, info, err = c.store.getRange(ctx, name, offset, length)
+	info.Key = key
+	return
+}
+
+// Get information about an object in the collection
+func (c *Collection) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	ctx, done := c.store.operation(ctx, "Collection.Stat")
+	defer done(&err)
+	name, err := c.object(key)
+	if err != nil {
+		return
+	}
+	info, err = c.store.stat(ctx, name)
+	info.Key = key
+	return
+}
+
+// List the keys of all the objects in the collection
+func (c *Collection) Keys(ctx context.Context) (keys []string, err error) {
+	ctx, done := c.store.operation(ctx, "Collection.Keys")
+	defer done(&err)
+	err = c.validate()
+	if err != nil {
+		return
+	}
+	prefix := collectionPrefix + c.name + "/"
+	for obj := range c.store.listObjects(ctx, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		keys = append(keys, strings.TrimPrefix(obj.Key, prefix))
+	}
+	return
+}
+
+// list the unique IDs of the objects put at the root of the bucket with
+// PutObject or PutStream, which are the objects outside the prefixes used by gost
+func (s *Store) rootObjects(ctx context.Context) (uids []string, err error) {
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		if !isReserved(obj.Key) && !strings.HasSuffix(obj.Key, "/") {
+			uids = append(uids, obj.Key)
+		}
+	}
+	return
+}
+
+// Move objects put at the root of the bucket with PutObject or PutStream into
+// the collection, keeping their unique IDs as keys
+// If no unique IDs are given, all objects at the root of the bucket are
+// moved, including ones with unique IDs like "docs/note.txt"
+func (c *Collection) Migrate(ctx context.Context, uids ...string) (moved []string, err error) {
+	ctx, done := c.store.longOperation(ctx, "Collection.Migrate")
+	defer done(&err)
+	err = c.validate()
+	if err != nil {
+		return
+	}
+	if len(uids) == 0 {
+		uids, err = c.store.rootObjects(ctx)
+		if err != nil {
+			return
+		}
+	}
+	for _, uid := range uids {
+		err = ValidateObjectName(uid)
+		if err != nil {
+			return
+		}
+		var name string
+		name, err = c.object(uid)
+		if err != nil {
+			return
+		}
+		// the object keeps its owner, so it's indexed under its new name
+		var info minio.ObjectInfo
+		info, err = c.store.statObject(ctx, uid)
+		if err != nil {
+			log.Println("Cannot stat object:", err)
+			return
+		}
+		err = c.store.indexOwner(ctx, name, info.UserMetadata)
+		if err != nil {
+			return
+		}
+		err = c.store.call(ctx, "copy object", func() (err error) {
+			_, err = c.store.client.CopyObject(ctx,
+				minio.CopyDestOptions{Bucket: c.store.bucket, Object: name},
+				minio.CopySrcOptions{Bucket: c.store.bucket, Object: uid})
+			return
+		})
+		if err != nil {
+			log.Println("Cannot copy object:", err)
+			return
+		}
+		err = c.store.removeObject(ctx, uid)
+		if err != nil {
+			log.Println("Cannot remove object:", err)
+			return
+		}
+		moved = append(moved, uid)
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package gost
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCollection(t *testing.T) {
+	setupObjects()
+	Register([]Thingy{})
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	invoices := store.Collection("invoices")
+	err = invoices.Put(ctx, "2022/invoice-1", objects)
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	obj, err := invoices.Get(ctx, "2022/invoice-1")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if obj.([]Thingy)[0].Name != "Bob" {
+		t.Errorf("Failed to get the right thingy")
+	}
+	keys, err := invoices.Keys(ctx)
+	if err != nil {
+		t.Errorf("Failed to list keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "2022/invoice-1" {
+		t.Errorf("Failed to get the right keys: %v", keys)
+	}
+	err = invoices.Delete(ctx, "2022/invoice-1")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+}
+
+func TestCollectionInvalidKeys(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"", "/abs", "a//b", "../data/x", "tab\tkey"} {
+		err = store.Collection("invoices").Put(ctx, key, "x")
+		if err == nil {
+			t.Errorf("Key %q should be rejected", key)
+		}
+	}
+	err = store.Collection("Invoices/2022").Put(ctx, "x", "x")
+	if err == nil {
+		t.Errorf("Collection name should be rejected")
+	}
+}
+
+func TestCollectionMigrate(t *testing.T) {
+	setupObjects()
+	Register([]Thingy{})
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.PutObject(ctx, "legacy-things", objects)
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	legacy := store.Collection("legacy")
+	moved, err := legacy.Migrate(ctx, "legacy-things")
+	if err != nil {
+		t.Errorf("Failed to migrate: %v", err)
+	}
+	if len(moved) != 1 {
+		t.Errorf("Failed to move the right objects: %v", moved)
+	}
+	obj, err := legacy.Get(ctx, "legacy-things")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if obj.([]Thingy)[1].Name != "Alice" {
+		t.Errorf("Failed to get the right thingy")
+	}
+	_, err = store.Stat(ctx, "legacy-things")
+	if !isNoSuchKey(err) {
+		t.Errorf("Legacy object should have been removed: %v", err)
+	}
+	// objects at the root are found wherever they are, but not objects in reserved prefixes
+	err = store.PutObject(ctx, "legacy-dir/thing", "a thing")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Put(ctx, "legacy-user", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	uids, err := store.rootObjects(ctx)
+	if err != nil {
+		t.Errorf("Failed to list root objects: %v", err)
+	}
+	found := false
+	for _, uid := range uids {
+		found = found || uid == "legacy-dir/thing"
+		if isReserved(uid) {
+			t.Errorf("%v is in a reserved prefix", uid)
+		}
+	}
+	if !found {
+		t.Errorf("Failed to find objects with / in their unique IDs: %v", uids)
+	}
+	err = store.DeleteObject(ctx, "legacy-dir/thing")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+	_, err = legacy.Migrate(ctx, "data/c2F1c2hlb25n.gob")
+	if err == nil {
+		t.Errorf("Objects in reserved prefixes should not be migrated")
+	}
+	err = legacy.Delete(ctx, "legacy-things")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+}
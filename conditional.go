@@ -0,0 +1,148 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrConflict is returned when data keeps being changed by someone else
+// while it's being updated, so the update can't be made
+var ErrConflict = errors.New("gost: too many concurrent updates")
+
+// the number of times an update is tried before giving up with ErrConflict
+const maxAttempts = 20
+
+// the longest to wait between tries of an update
+const maxBackoff = 500 * time.Millisecond
+
+type conditionKey struct{}
+
+// the conditions for a write to the object store
+type condition struct {
+	ifMatch     string
+	ifNoneMatch string
+	// the number of times the write was sent, which is more than once if the
+	// minio client sent it again after a failure
+	sent *int32
+}
+
+// make writes with the context succeed only if the object has the ETag
+func ifMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, conditionKey{}, condition{ifMatch: etag, sent: new(int32)})
+}
+
+// make writes with the context succeed only if the object doesn't exist
+func ifNoneMatch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, conditionKey{}, condition{ifNoneMatch: "*", sent: new(int32)})
+}
+
+// drop the conditions from a context, for writing other objects as part of a conditional write
+func unconditional(ctx context.Context) context.Context {
+	return context.WithValue(ctx, conditionKey{}, nil)
+}
+
+// get the conditions for writes with the context, if it has any
+func conditionOf(ctx context.Context) (cond condition, ok bool) {
+	cond, ok = ctx.Value(conditionKey{}).(condition)
+	return
+}
+
+// check if the error returned by the object store means the condition of a write failed
+func isPreconditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).Code == "PreconditionFailed"
+}
+
+// conditionalTransport adds the conditions in the context of a write or delete to its request
+// minio-go has no options for conditional writes, so they go in as headers
+type conditionalTransport struct {
+	http.RoundTripper
+}
+
+// check if a request writes or deletes an object, rather than sending or
+// abandoning a part of a multipart upload, which is written when the POST
+// completing the upload is sent
+func finishesWrite(req *http.Request) bool {
+	multipart := req.URL.Query().Has("uploadId")
+	switch req.Method {
+	case http.MethodPut, http.MethodDelete:
+		return !multipart
+	case http.MethodPost:
+		return multipart
+	}
+	return false
+}
+
+func (t conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cond, ok := conditionOf(req.Context())
+	if !ok || !finishesWrite(req) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	atomic.AddInt32(cond.sent, 1)
+	req = req.Clone(req.Context())
+	if cond.ifMatch != "" {
+		req.Header.Set("If-Match", `"`+cond.ifMatch+`"`)
+	}
+	if cond.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", cond.ifNoneMatch)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// change the data for a given unique ID with fn, without losing changes made
+// by anyone else at the same time
+// The data is written only if it hasn't changed since it was read, otherwise
+// it's read again and fn is called again, so fn must not have side effects
+func (s *Store) modify(ctx context.Context, uid string, fn func(all map[string]any) error) (err error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err = sleep(ctx, backoff(attempt))
+			if err != nil {
+				return
+			}
+		}
+		all, found, etag, err := s.getAll(ctx, uid)
+		if err != nil {
+			log.Println("Cannot get data during update:", err)
+			return err
+		}
+		err = fn(all)
+		if err != nil {
+			return err
+		}
+		err = s.putAll(ctx, uid, all, found, etag)
+		if !isPreconditionFailed(err) {
+			return err
+		}
+	}
+	log.Println("Cannot update data:", ErrConflict)
+	return ErrConflict
+}
+
+// how long to wait before trying an update again, growing with each attempt
+// and randomised so concurrent updates don't keep clashing
+func backoff(attempt int) time.Duration {
+	d := 10 * time.Millisecond << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// wait for the duration, or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+package gost
+
+import (
+	"context"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// WithTimeout gives each operation on a unique ID, key, object or lock a
+// timeout, used when the context passed in has no deadline of its own
+// It doesn't apply to creating the store, to operations on the whole store,
+// like BackupAll and Export, to Lock waiting for a lock, or to streaming with
+// PutStream and GetStream
+func WithTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.timeout = d
+	}
+}
+
+// start an operation, giving the context the store's timeout if it has no
+// deadline of its own
+// The returned function must be deferred with the operation's error, which
+// becomes the context's error if the context is done
+func (s *Store) operation(ctx context.Context, name string) (context.Context, func(*error)) {
+	cancel := func() {}
+	if _, ok := ctx.Deadline(); !ok && s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	ctx, end := s.observe(ctx, name)
+	return ctx, func(err *error) {
+		contextError(ctx, err)
+		end(*err)
+		cancel()
+	}
+}
+
+// start an operation that isn't given the store's timeout, like one on the
+// whole store
+// The returned function must be deferred with the operation's error, like
+// the one returned by operation
+func (s *Store) longOperation(ctx context.Context, name string) (context.Context, func(*error)) {
+	ctx, end := s.observe(ctx, name)
+	return ctx, func(err *error) {
+		contextError(ctx, err)
+		end(*err)
+	}
+}
+
+// replace the error with the context's error if the context is done, so
+// operations stopped by the context return its error
+func contextError(ctx context.Context, err *error) {
+	if *err != nil && ctx.Err() != nil {
+		*err = ctx.Err()
+	}
+}
+
+// an object being read, which stops with the context's error once the context
+// is done, even if what's being read was already fetched
+type objectReader struct {
+	*minio.Object
+	ctx   context.Context
+	store *Store
+}
+
+func (r objectReader) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return
+	}
+	n, err = r.Object.Read(p)
+	r.store.transferred(r.ctx, "read", int64(n))
+	if err != nil && r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return
+}
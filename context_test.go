@@ -0,0 +1,82 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestCancelledContext(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = store.Put(ctx, "context-test", "a", "first")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Put should fail with the context's error: %v", err)
+	}
+	_, err = store.GetAll(ctx, "context-test")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAll should fail with the context's error: %v", err)
+	}
+	_, err = store.Load(ctx, "context-test")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Load should fail with the context's error: %v", err)
+	}
+	_, err = NewStoreContext(ctx, key, secret, endpoint, useSSL, region, bucket)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("NewStoreContext should fail with the context's error: %v", err)
+	}
+}
+
+func TestReadStopsWhenCancelled(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	err = store.Put(context.Background(), "context-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	obj, err := store.openObject(ctx, name("context-test"), minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("Failed to open object: %v", err)
+	}
+	defer obj.Close()
+	_, err = obj.Read(make([]byte, 1))
+	if err != nil {
+		t.Errorf("Failed to read: %v", err)
+	}
+	cancel()
+	_, err = obj.Read(make([]byte, 1))
+	if err != context.Canceled {
+		t.Errorf("Read should stop with the context's error: %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithTimeout(time.Nanosecond))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	_, err = store.Get(context.Background(), "context-test", "a")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get should time out: %v", err)
+	}
+	// a deadline of the caller's own is used instead of the store's timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = store.Get(ctx, "context-test", "a")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+}
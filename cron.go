@@ -0,0 +1,149 @@
+package gost
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is when a scheduled job runs, parsed by ParseSchedule
+type Schedule struct {
+	// bit sets of the minutes, hours, days of the month, months and days of
+	// the week the job runs in
+	minute, hour, day, month, weekday uint64
+	// whether the day of the month or the day of the week was *, which changes
+	// how the two are combined
+	anyDay, anyWeekday bool
+	// how often @every schedules run
+	every time.Duration
+}
+
+// the cron expressions of the named schedules
+var namedSchedules = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a cron expression with five fields: minute, hour, day
+// of the month, month and day of the week
+// Fields can be *, numbers, ranges like 1-5, lists like 1,15 and steps like
+// */15 or 0-30/10. Months and days of the week are numbers, with Sunday as 0
+// or 7. Like cron, if both the day of the month and the day of the week are
+// restricted, the job runs on days matching either of them.
+// @yearly, @monthly, @weekly, @daily and @hourly can be used too, and so
+// can @every followed by a duration, like "@every 6h", which runs at
+// multiples of the duration since the Unix epoch
+func ParseSchedule(spec string) (sched Schedule, err error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		sched.every, err = time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err == nil && sched.every <= 0 {
+			err = fmt.Errorf("gost: schedule %q must be positive", spec)
+		}
+		return
+	}
+	expr := spec
+	if named, ok := namedSchedules[spec]; ok {
+		expr = named
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		err = fmt.Errorf("gost: schedule %q should have 5 fields", spec)
+		return
+	}
+	limits := []struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := []*uint64{&sched.minute, &sched.hour, &sched.day, &sched.month, &sched.weekday}
+	for i, field := range fields {
+		*sets[i], err = parseField(field, limits[i].min, limits[i].max)
+		if err != nil {
+			err = fmt.Errorf("gost: schedule %q: %v", spec, err)
+			return
+		}
+	}
+	// 7 is Sunday too
+	if sched.weekday&(1<<7) != 0 {
+		sched.weekday |= 1
+	}
+	sched.anyDay = strings.HasPrefix(fields[2], "*")
+	sched.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return
+}
+
+// parse a field of a cron expression into the set of values it matches
+func parseField(field string, min int, max int) (set uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		expr, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("%q has a bad step", part)
+			}
+		}
+		lo, hi := min, max
+		if expr != "*" {
+			from, to, isRange := strings.Cut(expr, "-")
+			lo, err = strconv.Atoi(from)
+			hi = lo
+			if err == nil && isRange {
+				hi, err = strconv.Atoi(to)
+			} else if hasStep {
+				// like cron, 5/10 means from 5 to the end in steps of 10
+				hi = max
+			}
+			if err != nil || lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is not in %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+// Next returns the first time after t that the schedule runs, or the zero
+// time if it never runs, like on the 30th of February
+func (sched Schedule) Next(t time.Time) time.Time {
+	if sched.every > 0 {
+		// count from the Unix epoch rather than from t, so every replica
+		// running the schedule gets the same times
+		epoch := time.Unix(0, 0).In(t.Location())
+		return epoch.Add((t.Sub(epoch)/sched.every + 1) * sched.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule that can run at all runs within a few years, because of leap years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case sched.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location())
+		case !sched.matchDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+		case sched.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+		case sched.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// check if the schedule runs on the day of t
+func (sched Schedule) matchDay(t time.Time) bool {
+	day := sched.day&(1<<uint(t.Day())) != 0
+	weekday := sched.weekday&(1<<uint(t.Weekday())) != 0
+	if sched.anyDay || sched.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
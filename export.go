@@ -0,0 +1,328 @@
+package gost
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ImportMode is what Import does with objects that are already in the store
+type ImportMode int
+
+const (
+	// Merge the data for each unique ID key by key, with the data from the
+	// archive replacing the data in the store for the same key. Other objects
+	// are overwritten
+	ImportMerge ImportMode = iota
+	// Overwrite objects in the store with the objects in the archive
+	ImportOverwrite
+	// Skip objects that are already in the store
+	ImportSkip
+)
+
+func (mode ImportMode) String() string {
+	switch mode {
+	case ImportMerge:
+		return "merge"
+	case ImportOverwrite:
+		return "overwrite"
+	case ImportSkip:
+		return "skip"
+	}
+	return fmt.Sprintf("ImportMode(%d)", int(mode))
+}
+
+// ParseImportMode parses the name of an import mode, which is merge, overwrite or skip
+func ParseImportMode(name string) (mode ImportMode, err error) {
+	for _, mode = range []ImportMode{ImportMerge, ImportOverwrite, ImportSkip} {
+		if mode.String() == name {
+			return
+		}
+	}
+	err = fmt.Errorf("unknown import mode %q", name)
+	return
+}
+
+// PAX record names used to keep object information in archives
+const (
+	paxContentType = "GOST.content-type"
+	paxETag        = "GOST.etag"
+	paxMeta        = "GOST.meta."
+	paxTag         = "GOST.tag."
+)
+
+// the prefixes of objects that belong to the store they are in rather than
+// its data, so they aren't exported or imported
+// Locks and job statuses are about the processes using the store, audit
+// records are about what happened to it, and the owner index is rebuilt from
+// the objects as they are imported
+var storePrefixes = []string{locksPrefix, auditPrefix, manifestsPrefix, ownersPrefix}
+
+// check if an object belongs to the store it's in rather than its data
+func isStoreObject(objectName string) bool {
+	for _, prefix := range storePrefixes {
+		if strings.HasPrefix(objectName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Export everything in the store into a tar archive written to w
+// This includes the data for every unique ID, the backups, the history,
+// published files and raw objects, together with their content types, user
+// metadata and tags. Locks, audit records, manifests and job statuses are
+// left out
+// Returns the number of objects exported
+func (s *Store) Export(ctx context.Context, w io.Writer) (n int, err error) {
+	ctx, done := s.longOperation(ctx, "Export")
+	defer done(&err)
+	tw := tar.NewWriter(w)
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		if isStoreObject(obj.Key) {
+			continue
+		}
+		var exported bool
+		exported, err = s.exportObject(ctx, tw, obj.Key)
+		if err != nil {
+			return
+		}
+		if exported {
+			n++
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+	}
+	return
+}
+
+// open an object for reading together with its information, making sure
+// they are from the same version of the object
+// If the object changes in between, it's tried again, and if it has been
+// removed since it was listed, found is false
+func (s *Store) openVersion(ctx context.Context, objectName string) (obj objectReader, info ObjectInfo, found bool, err error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err = sleep(ctx, backoff(attempt))
+			if err != nil {
+				return
+			}
+		}
+		info, err = s.stat(ctx, objectName)
+		if isNoSuchKey(err) {
+			return obj, info, false, nil
+		}
+		if err != nil {
+			return
+		}
+		options := minio.GetObjectOptions{}
+		err = options.SetMatchETag(info.ETag)
+		if err != nil {
+			log.Println("Cannot set etag:", err)
+			return
+		}
+		obj, err = s.openObject(ctx, objectName, options)
+		if err == nil {
+			_, err = obj.Stat()
+			if err != nil {
+				obj.Close()
+			}
+		}
+		if isNoSuchKey(err) {
+			return obj, info, false, nil
+		}
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot get object:", err)
+			return
+		}
+		return obj, info, true, nil
+	}
+	log.Println("Cannot get object:", ErrConflict)
+	err = ErrConflict
+	return
+}
+
+// write a single object into the archive, exported is false if it has been
+// removed since it was listed
+func (s *Store) exportObject(ctx context.Context, tw *tar.Writer, objectName string) (exported bool, err error) {
+	obj, info, exported, err := s.openVersion(ctx, objectName)
+	if err != nil || !exported {
+		return
+	}
+	defer obj.Close()
+	records := map[string]string{
+		paxContentType: info.ContentType,
+		paxETag:        info.ETag,
+	}
+	for k, v := range info.Metadata {
+		records[paxMeta+k] = v
+	}
+	for k, v := range info.Tags {
+		records[paxTag+k] = v
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       objectName,
+		Size:       info.Size,
+		Mode:       0644,
+		ModTime:    info.LastModified,
+		Format:     tar.FormatPAX,
+		PAXRecords: records,
+	})
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+		return
+	}
+	_, err = io.Copy(tw, obj)
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+	}
+	return
+}
+
+// Import everything in a tar archive written by Export into the store
+// The mode decides what happens to objects that are already in the store
+// Returns the number of objects imported, not counting skipped ones
+func (s *Store) Import(ctx context.Context, r io.Reader, mode ImportMode) (n int, err error) {
+	ctx, done := s.longOperation(ctx, "Import")
+	defer done(&err)
+	// references are counted once everything that can be is imported, as the
+	// blobs they refer to can come after them in the archive
+	changes := make(refChanges)
+	defer func() {
+		refsErr := s.applyRefs(ctx, changes)
+		if err == nil {
+			err = refsErr
+		}
+	}()
+	tr := tar.NewReader(r)
+	for {
+		var header *tar.Header
+		header, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			log.Println("Cannot read archive:", err)
+			return
+		}
+		// archives written by older versions of gost have the objects that
+		// belong to the store they came from
+		if header.Typeflag != tar.TypeReg || isStoreObject(header.Name) {
+			continue
+		}
+		err = validatePath("object", header.Name)
+		if err != nil {
+			return
+		}
+		var imported bool
+		imported, err = s.importObject(ctx, tr, header, mode, changes)
+		if err != nil {
+			return
+		}
+		if imported {
+			n++
+		}
+	}
+}
+
+// put a single object from the archive into the store
+// Content-addressed blobs are only put if they're not in the store already,
+// whatever the mode, and the references to them are counted in changes
+func (s *Store) importObject(ctx context.Context, r io.Reader, header *tar.Header, mode ImportMode, changes refChanges) (imported bool, err error) {
+	opts := importOptions(header)
+	if isBlob(header.Name) {
+		return s.putBlob(ctx, header.Name, r, opts)
+	}
+	var existing minio.ObjectInfo
+	if mode == ImportSkip || isReference(header.Name) {
+		existing, err = s.statObject(ctx, header.Name)
+		if err == nil && mode == ImportSkip {
+			return
+		}
+		if err != nil && !isNoSuchKey(err) {
+			log.Println("Cannot stat object:", err)
+			return
+		}
+	}
+	if mode == ImportMerge && strings.HasPrefix(header.Name, "data/") {
+		return true, s.mergeMap(ctx, r, header.Name)
+	}
+	_, err = s.putStream(ctx, header.Name, r, opts)
+	if err != nil {
+		return
+	}
+	if isReference(header.Name) {
+		changes.replace(refBlob(existing.UserMetadata), refBlob(opts.Metadata))
+	}
+	return true, nil
+}
+
+// get the options to put an object from the archive with
+func importOptions(header *tar.Header) (opts PutOptions) {
+	opts = PutOptions{
+		Size:        header.Size,
+		ContentType: header.PAXRecords[paxContentType],
+		Metadata:    make(map[string]string),
+		Tags:        make(map[string]string),
+	}
+	for k, v := range header.PAXRecords {
+		if strings.HasPrefix(k, paxMeta) {
+			opts.Metadata[strings.TrimPrefix(k, paxMeta)] = v
+		}
+		if strings.HasPrefix(k, paxTag) {
+			opts.Tags[strings.TrimPrefix(k, paxTag)] = v
+		}
+	}
+	return
+}
+
+// merge the map in the archive into the map in the store, without losing
+// changes made to it at the same time
+func (s *Store) mergeMap(ctx context.Context, r io.Reader, objectName string) (err error) {
+	imported, _, err := decodeMap(r)
+	if err != nil {
+		return
+	}
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err = sleep(ctx, backoff(attempt))
+			if err != nil {
+				return
+			}
+		}
+		all, found, etag, err := s.readMap(ctx, objectName)
+		if err != nil {
+			return err
+		}
+		for k, v := range imported {
+			all[k] = v
+		}
+		writeCtx := ifNoneMatch(ctx)
+		if found != "" {
+			writeCtx = ifMatch(ctx, etag)
+		}
+		err = s.writeMap(writeCtx, objectName, all)
+		if !isPreconditionFailed(err) {
+			return err
+		}
+	}
+	log.Println("Cannot merge data:", ErrConflict)
+	return ErrConflict
+}
@@ -0,0 +1,197 @@
+package gost
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportAndImport(t *testing.T) {
+	setup()
+	Register(Thingy{})
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithAudit())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	target, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-import")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "export-test", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.PutObject(ctx, "export-object", "an object", PutOptions{Metadata: map[string]string{"Owner": "export-test"}})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+
+	// a raw object with a name like the ones gost keeps for itself is exported
+	err = store.PutObject(ctx, "locks/export-object", "not a lock")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, _, err = store.PublishContent(ctx, "export-avatar.txt", "text/plain", []byte("an avatar"))
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	lease, err := store.TryLock(ctx, "export-lock", time.Minute)
+	if err != nil {
+		t.Errorf("Failed to lock: %v", err)
+	}
+	defer lease.Release(ctx)
+
+	var archive bytes.Buffer
+	n, err := store.Export(ctx, &archive)
+	if err != nil {
+		t.Errorf("Failed to export: %v", err)
+	}
+	if n == 0 {
+		t.Errorf("Failed to export any objects")
+	}
+	// locks, audit records and other objects about the store itself are left out
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	var rawLock bool
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if strings.HasPrefix(header.Name, locksPrefix) || strings.HasPrefix(header.Name, auditPrefix) {
+			t.Errorf("%v should not be exported", header.Name)
+		}
+		rawLock = rawLock || header.Name == "locks/export-object"
+	}
+	if !rawLock {
+		t.Errorf("Raw object in locks/ should be exported")
+	}
+
+	// data in the target that isn't in the archive is kept when merging
+	err = target.Put(ctx, "export-test", "456", "target data")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = target.Import(ctx, bytes.NewReader(archive.Bytes()), ImportMerge)
+	if err != nil {
+		t.Errorf("Failed to import: %v", err)
+	}
+	all, err := target.GetAll(ctx, "export-test")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if all["123"] != "hello world!" || all["456"] != "target data" {
+		t.Errorf("Failed to merge the data: %v", all)
+	}
+	info, err := target.Stat(ctx, "export-object")
+	if err != nil {
+		t.Errorf("Failed to stat: %v", err)
+	}
+	if info.Metadata["Owner"] != "export-test" {
+		t.Errorf("Failed to import the metadata: %v", info.Metadata)
+	}
+	ref, err := target.statObject(ctx, "public/export-avatar.txt")
+	if err != nil {
+		t.Errorf("Failed to stat: %v", err)
+	}
+	if redirect := ref.Metadata.Get("X-Amz-Website-Redirect-Location"); redirect != target.redirect(refBlob(ref.UserMetadata)) {
+		t.Errorf("Reference should redirect to its blob in the target: %q", redirect)
+	}
+
+	// existing objects are left alone when skipping
+	err = target.Put(ctx, "export-test", "123", "changed")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = target.Import(ctx, bytes.NewReader(archive.Bytes()), ImportSkip)
+	if err != nil {
+		t.Errorf("Failed to import: %v", err)
+	}
+	data, err := target.Get(ctx, "export-test", "123")
+	if err != nil || data != "changed" {
+		t.Errorf("Existing data should be skipped: %v, %v", data, err)
+	}
+
+	// and replaced when overwriting
+	_, err = target.Import(ctx, bytes.NewReader(archive.Bytes()), ImportOverwrite)
+	if err != nil {
+		t.Errorf("Failed to import: %v", err)
+	}
+	all, err = target.GetAll(ctx, "export-test")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if all["123"] != "hello world!" || all["456"] != nil {
+		t.Errorf("Failed to overwrite the data: %v", all)
+	}
+}
+
+func TestImportRefs(t *testing.T) {
+	setup()
+	source, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-refs-src")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	target, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-refs-dst")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// the same content is published in both stores, under different names
+	content := []byte("shared at " + time.Now().String())
+	hash, _, err := target.PublishContent(ctx, "refs-target.txt", "text/plain", content)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	_, _, err = source.PublishContent(ctx, "refs-source.txt", "text/plain", content)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	var archive bytes.Buffer
+	_, err = source.Export(ctx, &archive)
+	if err != nil {
+		t.Errorf("Failed to export: %v", err)
+	}
+	_, err = target.Import(ctx, &archive, ImportMerge)
+	if err != nil {
+		t.Errorf("Failed to import: %v", err)
+	}
+
+	// the imported reference is counted along with the one already there
+	err = target.Unpublish(ctx, "refs-target.txt")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = target.statObject(ctx, blob(hash, "text/plain"))
+	if err != nil {
+		t.Errorf("Blob that's still referred to should be kept: %v", err)
+	}
+	err = target.Unpublish(ctx, "refs-source.txt")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = target.statObject(ctx, blob(hash, "text/plain"))
+	if !isNoSuchKey(err) {
+		t.Errorf("Blob that nothing refers to should be removed: %v", err)
+	}
+}
+
+func TestParseImportMode(t *testing.T) {
+	for _, mode := range []ImportMode{ImportMerge, ImportOverwrite, ImportSkip} {
+		parsed, err := ParseImportMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("Failed to parse %v: %v", mode, err)
+		}
+	}
+	_, err := ParseImportMode("replace")
+	if err == nil {
+		t.Errorf("Unknown mode should not be parsed")
+	}
+}
@@ -1,74 +1,133 @@
 package gost
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/gob"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/minio/minio-go/v7"
 )
 
-// get the name of the object to store
-func name(uid string) string {
-	return "data/" + base64.StdEncoding.EncodeToString([]byte(uid)) + ".gob"
+// check if the error returned by the object store means the object doesn't exist
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
 }
 
-// Put a piece of data in the database, with a unique ID
-// Each piece of data is associated with a key
-func (s *Store) Put(ctx context.Context, uid string, key string, data any) (err error) {
-	all, err := s.GetAll(ctx, uid)
-	if err != nil {
-		log.Println("Cannot get data during put:", err)
+// read the map stored in the first of the named objects that exists, and the ETag of the object
+// If none of them exist, returns an empty map and found is empty
+func (s *Store) readMap(ctx context.Context, names ...string) (data map[string]any, found string, etag string, err error) {
+	for _, objectName := range names {
+		var obj objectReader
+		obj, err = s.openObject(ctx, objectName, minio.GetObjectOptions{})
+		if err != nil {
+			log.Println("Cannot get object:", err)
+			return
+		}
+		var info minio.ObjectInfo
+		info, err = obj.Stat()
+		if err != nil {
+			obj.Close()
+			// No such key here means this is the data doesn't exist, try the next name
+			if isNoSuchKey(err) {
+				err = nil
+				continue
+			}
+			log.Printf("Object doesn't exist:\n %#v, %v\n", obj, err)
+			return
+		}
+		var stale bool
+		data, stale, err = decodeMap(obj)
+		err = inObject(err, objectName)
+		obj.Close()
+		found, etag = objectName, info.ETag
+		if err == nil && stale && s.migrationWriteBack {
+			// failing to write back doesn't stop the data from being read, and
+			// if the data has changed since it was read it doesn't need writing back
+			s.writeMap(ifMatch(ctx, etag), objectName, data)
+		}
 		return
 	}
-	all[key] = data
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(all)
+	data = make(map[string]any)
+	return
+}
+
+// write the map into the named object
+func (s *Store) writeMap(ctx context.Context, objectName string, data map[string]any) (err error) {
+	buf, err := encodeMap(data)
 	if err != nil {
-		log.Println("Cannot encode gob:", err)
 		return
 	}
-	s.client.PutObject(ctx, s.bucket, name(uid), &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
-	if err != nil {
+	_, err = s.upload(ctx, objectName, buf.Bytes(),
+		minio.PutObjectOptions{ContentType: "application/octet-stream", UserMetadata: codecMetadata()})
+	// a failed condition is expected when the data is written concurrently, and is retried
+	if err != nil && !isPreconditionFailed(err) {
 		log.Println("Cannot put object:", err)
 	}
-
 	return
 }
 
-// Get all the data for a given unique ID
-func (s *Store) GetAll(ctx context.Context, uid string) (data map[string]any, err error) {
-	obj, err := s.client.GetObject(ctx, s.bucket, name(uid), minio.GetObjectOptions{})
-	if err != nil {
-		log.Println("Cannot get object:", err)
+// remove the object with the legacy name once the data has been written with the current name
+func (s *Store) removeLegacy(ctx context.Context, found string, current string) (err error) {
+	if found == "" || found == current {
 		return
 	}
-	defer obj.Close()
-	_, err = obj.Stat()
+	err = s.removeObject(ctx, found)
+	if err != nil {
+		log.Println("Cannot remove legacy object:", err)
+	}
+	return
+}
+
+// get all the data for a given unique ID, and the name and ETag of the object it was read from
+func (s *Store) getAll(ctx context.Context, uid string) (data map[string]any, found string, etag string, err error) {
+	return s.readMap(ctx, name(uid), legacyName(uid))
+}
+
+// replace all the data for a given unique ID, as long as it hasn't changed
+// since it was read from the found object with the ETag
+func (s *Store) putAll(ctx context.Context, uid string, data map[string]any, found string, etag string) (err error) {
+	writeCtx := ifNoneMatch(ctx)
+	if found == name(uid) {
+		writeCtx = ifMatch(ctx, etag)
+	}
+	err = s.writeMap(writeCtx, name(uid), data)
 	if err != nil {
-		// No such key here means this is the data doesn't exist, returns an empty map
-		if err.(minio.ErrorResponse).Code == "NoSuchKey" {
-			data = make(map[string]any)
-			err = nil
-		} else {
-			log.Printf("Object doesn't exist:\n %#v, %v\n", obj, err)
-		}
 		return
 	}
-	decoder := gob.NewDecoder(obj)
-	err = decoder.Decode(&data)
+	return s.removeLegacy(ctx, found, name(uid))
+}
+
+// Put a piece of data in the database, with a unique ID
+// Each piece of data is associated with a key
+func (s *Store) Put(ctx context.Context, uid string, key string, data any) (err error) {
+	ctx, done := s.operation(ctx, "Put")
+	defer done(&err)
+	return s.modifyKey(ctx, "put", uid, key, func(current any, exists bool) (any, error) {
+		return data, nil
+	})
+}
+
+// Get all the data for a given unique ID
+func (s *Store) GetAll(ctx context.Context, uid string) (data map[string]any, err error) {
+	ctx, done := s.operation(ctx, "GetAll")
+	defer done(&err)
+	err = ValidateUID(uid)
 	if err != nil {
-		log.Println("Cannot decode data:", err)
+		return
 	}
+	data, _, _, err = s.getAll(ctx, uid)
 	return
 }
 
 // Get a specific piece of data for a given unique ID
 func (s *Store) Get(ctx context.Context, uid string, key string) (data any, err error) {
+	ctx, done := s.operation(ctx, "Get")
+	defer done(&err)
+	err = ValidateKey(key)
+	if err != nil {
+		return
+	}
 	all, err := s.GetAll(ctx, uid)
 	if err != nil {
 		return
@@ -79,38 +138,57 @@ func (s *Store) Get(ctx context.Context, uid string, key string) (data any, err
 
 // Delete a specific piece of data for a given unique ID
 func (s *Store) Delete(ctx context.Context, uid string, key string) (err error) {
-	all, err := s.GetAll(ctx, uid)
+	ctx, done := s.operation(ctx, "Delete")
+	defer done(&err)
+	return s.deleteKey(ctx, "delete", uid, key)
+}
+
+// Delete all data for a given unique ID
+func (s *Store) DeleteAll(ctx context.Context, uid string) (err error) {
+	ctx, done := s.operation(ctx, "DeleteAll")
+	defer done(&err)
+	err = ValidateUID(uid)
 	if err != nil {
 		return
 	}
-	delete(all, key)
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(all)
+	var old map[string]any
+	if s.audit || s.history {
+		old, _, _, err = s.getAll(ctx, uid)
+		if err != nil {
+			return
+		}
+	}
+	err = s.writeMap(ctx, name(uid), make(map[string]any))
 	if err != nil {
-		log.Println("Cannot encode gob:", err)
+		return
 	}
-	s.client.PutObject(ctx, s.bucket, name(uid), &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	err = s.removeLegacy(ctx, legacyName(uid), name(uid))
 	if err != nil {
-		log.Println("Cannot put object:", err)
+		return
 	}
+	s.record(ctx, "delete-all", uid, "", hashMap(old), "")
+	s.recordHistory(ctx, "delete-all", uid, old, nil)
 	return
 }
 
-// Delete all data for a given unique ID
-func (s *Store) DeleteAll(ctx context.Context, uid string) (err error) {
-	empty := make(map[string]any)
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(empty)
-	if err != nil {
-		log.Println("Cannot encode gob:", err)
-	}
-	s.client.PutObject(ctx, s.bucket, name(uid), &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
-	if err != nil {
-		log.Println("Cannot put object:", err)
+// List the unique IDs that have data in the database
+func (s *Store) UIDs(ctx context.Context) (uids []string, err error) {
+	ctx, done := s.longOperation(ctx, "UIDs")
+	defer done(&err)
+	seen := make(map[string]bool)
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: "data/", Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		uid, decodeErr := decode(strings.TrimSuffix(strings.TrimPrefix(obj.Key, "data/"), ".gob"))
+		if decodeErr != nil || seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
 	}
+	sort.Strings(uids)
 	return
 }
@@ -225,3 +225,26 @@ func TestLargeFile(t *testing.T) {
 		t.Errorf("Failed to store: %v", err)
 	}
 }
+
+func TestUIDs(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	err = store.Put(context.Background(), "uids-test@example.com", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	uids, err := store.UIDs(context.Background())
+	if err != nil {
+		t.Errorf("Failed to list: %v", err)
+	}
+	found := false
+	for _, uid := range uids {
+		found = found || uid == "uids-test@example.com"
+	}
+	if !found {
+		t.Errorf("Failed to list the unique ID: %v", uids)
+	}
+}
@@ -0,0 +1,382 @@
+// Package gosthttp serves a gost Store over HTTP, as a REST API with JSON in and out
+//
+// Each request is authenticated with an AuthFunc, which maps the request to
+// the unique ID it is allowed to access, so users can only see their own data.
+//
+// The routes are:
+//
+//	GET    /users/{uid}                       all the data for a unique ID
+//	GET    /users/{uid}/keys/{key}            the data for a key
+//	PUT    /users/{uid}/keys/{key}            put the JSON value in the body in a key
+//	DELETE /users/{uid}/keys/{key}            delete a key
+//	POST   /users/{uid}/backup                back up the data for a unique ID
+//	POST   /users/{uid}/restore               restore the data for a unique ID from its backup
+//	GET    /users/{uid}/objects/{name}        get a raw object
+//	PUT    /users/{uid}/objects/{name}        put the body as a raw object
+//	DELETE /users/{uid}/objects/{name}        delete a raw object
+//	PUT    /users/{uid}/published/{filename}  publish the body as a file
+//	DELETE /users/{uid}/published/{filename}  unpublish a file
+//
+// Unique IDs and keys must be path escaped. Raw objects are kept in the
+// "users" collection and published files are published under a directory,
+// both named after the unique ID, so they are separate for each user. They
+// are owned by the unique ID, so they are included by Store.ExportUser and
+// removed by Store.PurgeUser.
+//
+// The bodies of requests putting keys and publishing files are read into
+// memory, so they are limited to DefaultMaxBodySize, or the size set with
+// WithMaxBodySize, and larger ones are rejected with 413 Request Entity Too
+// Large. Raw objects are streamed to the store, so they aren't limited.
+// Raw objects are served as attachments, so a browser never renders them
+// on the API's origin.
+//
+// Restoring a unique ID without a backup fails with 404 Not Found, a
+// corrupt backup with 422 Unprocessable Entity, and an update that keeps
+// clashing with other updates with 409 Conflict.
+package gosthttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/sausheong/gost"
+)
+
+func init() {
+	// JSON objects and arrays put in keys
+	gost.Register(map[string]any{})
+	gost.Register([]any{})
+}
+
+// ErrUnauthorized can be returned by an AuthFunc when the request has no valid credentials
+var ErrUnauthorized = errors.New("unauthorized")
+
+// AuthFunc maps a request to the unique ID it is allowed to access
+// Returning an error rejects the request with 401 Unauthorized
+type AuthFunc func(r *http.Request) (uid string, err error)
+
+// DefaultMaxBodySize is the largest body a request putting a key or
+// publishing a file can have, unless it's changed with WithMaxBodySize
+const DefaultMaxBodySize = 10 << 20
+
+// Handler serves a Store over HTTP
+type Handler struct {
+	store       *gost.Store
+	auth        AuthFunc
+	maxBodySize int64
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithMaxBodySize sets the largest body, in bytes, a request putting a key or
+// publishing a file can have
+func WithMaxBodySize(n int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = n
+	}
+}
+
+// Create a new handler for the store, authenticating requests with auth, which must not be nil
+func NewHandler(store *gost.Store, auth AuthFunc, opts ...Option) *Handler {
+	h := &Handler{store: store, auth: auth, maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// an error with the HTTP status it should be reported with
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+var (
+	errNotFound         = &httpError{http.StatusNotFound, "not found"}
+	errMethodNotAllowed = &httpError{http.StatusMethodNotAllowed, "method not allowed"}
+	errForbidden        = &httpError{http.StatusForbidden, "forbidden"}
+	errTooLarge         = &httpError{http.StatusRequestEntityTooLarge, "request body too large"}
+)
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// browsers must not guess a more dangerous content type than the one given
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	err := h.serve(w, r)
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// route the request, after checking that it's for the unique ID it's allowed to access
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) (err error) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if len(segments) < 2 || segments[0] != "users" {
+		return errNotFound
+	}
+	for i := range segments {
+		segments[i], err = pathUnescape(segments[i])
+		if err != nil {
+			return
+		}
+	}
+	uid := segments[1]
+	allowed, err := h.auth(r)
+	if err != nil {
+		return &httpError{http.StatusUnauthorized, err.Error()}
+	}
+	if uid != allowed {
+		return errForbidden
+	}
+	// changes are audited as made by the user, unless the actor was already set
+	if gost.ActorFromContext(r.Context()) == "" {
+		r = r.WithContext(gost.ContextWithActor(r.Context(), uid))
+	}
+
+	ctx := r.Context()
+	switch {
+	case len(segments) == 2:
+		if r.Method != http.MethodGet {
+			return errMethodNotAllowed
+		}
+		var all map[string]any
+		all, err = h.store.GetAll(ctx, uid)
+		if err != nil {
+			return
+		}
+		return writeJSON(w, http.StatusOK, all)
+
+	case len(segments) == 4 && segments[2] == "keys":
+		return h.serveKey(w, r, uid, segments[3])
+
+	case len(segments) == 3 && segments[2] == "backup":
+		if r.Method != http.MethodPost {
+			return errMethodNotAllowed
+		}
+		err = h.store.Backup(ctx, uid)
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) == 3 && segments[2] == "restore":
+		if r.Method != http.MethodPost {
+			return errMethodNotAllowed
+		}
+		err = h.store.Restore(ctx, uid)
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) > 3 && segments[2] == "objects":
+		return h.serveObject(w, r, uid, strings.Join(segments[3:], "/"))
+
+	case len(segments) > 3 && segments[2] == "published":
+		return h.servePublished(w, r, uid, strings.Join(segments[3:], "/"))
+
+	default:
+		return errNotFound
+	}
+	return
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, uid string, key string) (err error) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		var all map[string]any
+		all, err = h.store.GetAll(ctx, uid)
+		if err != nil {
+			return
+		}
+		data, ok := all[key]
+		if !ok {
+			return errNotFound
+		}
+		return writeJSON(w, http.StatusOK, data)
+	case http.MethodPut:
+		var data any
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodySize)).Decode(&data)
+		if err != nil {
+			if isTooLarge(err) {
+				return errTooLarge
+			}
+			return &httpError{http.StatusBadRequest, "cannot decode JSON: " + err.Error()}
+		}
+		err = h.store.Put(ctx, uid, key, data)
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err = h.store.Delete(ctx, uid, key)
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return errMethodNotAllowed
+	}
+	return
+}
+
+// the name of a raw object or published file, within the directory for the unique ID
+func userPath(uid string, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(uid)) + "/" + name
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, uid string, name string) (err error) {
+	ctx := r.Context()
+	objects := h.store.Collection("users")
+	switch r.Method {
+	case http.MethodGet:
+		var body io.ReadCloser
+		var info gost.ObjectInfo
+		body, info, err = objects.GetStream(ctx, userPath(uid, name))
+		if err != nil {
+			return
+		}
+		defer body.Close()
+		// the content type is whatever was uploaded, so the object is
+		// downloaded instead of being rendered on the API's origin
+		w.Header().Set("Content-Type", info.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.Header().Set("ETag", `"`+strings.Trim(info.ETag, `"`)+`"`)
+		w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		_, err = io.Copy(w, body)
+		if err != nil {
+			// the status has been written, so the error can only be logged
+			log.Println("Cannot write object:", err)
+			err = nil
+		}
+	case http.MethodPut:
+		var info gost.ObjectInfo
+		info, err = objects.PutStream(ctx, userPath(uid, name), r.Body, gost.PutOptions{
+			Size:        r.ContentLength,
+			ContentType: r.Header.Get("Content-Type"),
+			Owner:       uid,
+		})
+		if err != nil {
+			return
+		}
+		info.Key = name
+		return writeJSON(w, http.StatusOK, info)
+	case http.MethodDelete:
+		err = objects.Delete(ctx, userPath(uid, name))
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return errMethodNotAllowed
+	}
+	return
+}
+
+func (h *Handler) servePublished(w http.ResponseWriter, r *http.Request, uid string, filename string) (err error) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodPut:
+		var data []byte
+		data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+		if isTooLarge(err) {
+			return errTooLarge
+		}
+		if err != nil {
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		var hash, location string
+		hash, location, err = h.store.Publish(ctx, userPath(uid, filename), contentType, data, gost.PutOptions{Owner: uid})
+		if err != nil {
+			return
+		}
+		return writeJSON(w, http.StatusOK, map[string]string{"hash": hash, "location": location})
+	case http.MethodDelete:
+		err = h.store.Unpublish(ctx, userPath(uid, filename))
+		if err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return errMethodNotAllowed
+	}
+	return
+}
+
+// check if reading the request body failed because it's larger than allowed
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// unescape a path segment, reporting errors as bad requests
+func pathUnescape(segment string) (string, error) {
+	s, err := url.PathUnescape(segment)
+	if err != nil {
+		return "", &httpError{http.StatusBadRequest, "cannot unescape path: " + err.Error()}
+	}
+	return s, nil
+}
+
+// write the value as JSON with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) (err error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(append(body, '\n'))
+	if err != nil {
+		log.Println("Cannot write response:", err)
+		err = nil
+	}
+	return
+}
+
+// write the error as JSON, with a status that depends on the error
+// Errors from the store can say things about the bucket, so they are logged
+// and the client only gets the status text
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpErr *httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status, message = httpErr.status, httpErr.message
+	case errors.Is(err, gost.ErrInvalidName):
+		status, message = http.StatusBadRequest, err.Error()
+	case minio.ToErrorResponse(err).Code == "NoSuchKey":
+		status, message = http.StatusNotFound, errNotFound.message
+	case errors.Is(err, gost.ErrNoSuchGeneration):
+		status, message = http.StatusNotFound, "no backup"
+	case errors.Is(err, gost.ErrConflict):
+		status, message = http.StatusConflict, "too many concurrent updates"
+	case errors.Is(err, gost.ErrCorruptBackup):
+		status, message = http.StatusUnprocessableEntity, "backup is corrupt"
+	default:
+		log.Println("Cannot serve request:", err)
+	}
+	writeJSON(w, status, map[string]string{"error": message})
+}
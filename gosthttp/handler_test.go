@@ -0,0 +1,224 @@
+package gosthttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/sausheong/gost"
+)
+
+var key, secret, endpoint, region, bucket string
+var useSSL bool
+
+func setup() {
+	err := godotenv.Load("../.env")
+	if err != nil {
+		log.Printf("Failed to load the env vars: %v", err)
+	}
+	key = os.Getenv("KEY")
+	secret = os.Getenv("SECRET")
+	endpoint = os.Getenv("ENDPOINT")
+	useSSL, err = strconv.ParseBool((os.Getenv("USE_SSL")))
+	if err != nil {
+		log.Fatalf("Failed to parse USE_SSL: %v", err)
+	}
+	region = os.Getenv("REGION")
+	bucket = os.Getenv("BUCKET")
+}
+
+// authenticate with the unique ID in the X-User header
+func auth(r *http.Request) (uid string, err error) {
+	uid = r.Header.Get("X-User")
+	if uid == "" {
+		err = ErrUnauthorized
+	}
+	return
+}
+
+// make a request to the server as the given user
+func request(t *testing.T, server *httptest.Server, user string, method string, path string, body []byte) (resp *http.Response, data []byte) {
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return
+}
+
+func TestKeys(t *testing.T) {
+	setup()
+	store, err := gost.NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	server := httptest.NewServer(NewHandler(store, auth))
+	defer server.Close()
+	uid := "http-test@example.com"
+	path := "/users/" + url.PathEscape(uid)
+
+	resp, _ := request(t, server, uid, http.MethodPut, path+"/keys/prefs", []byte(`{"theme": "dark", "size": 12}`))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Failed to put: %v", resp.Status)
+	}
+	resp, body := request(t, server, uid, http.MethodGet, path+"/keys/prefs", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Failed to get: %v", resp.Status)
+	}
+	var prefs map[string]any
+	err = json.Unmarshal(body, &prefs)
+	if err != nil || prefs["theme"] != "dark" || prefs["size"] != 12.0 {
+		t.Errorf("Failed to get the right data: %s, %v", body, err)
+	}
+	resp, body = request(t, server, uid, http.MethodGet, path, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte(`"prefs"`)) {
+		t.Errorf("Failed to get all: %v, %s", resp.Status, body)
+	}
+	resp, _ = request(t, server, uid, http.MethodDelete, path+"/keys/prefs", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Failed to delete: %v", resp.Status)
+	}
+	resp, _ = request(t, server, uid, http.MethodGet, path+"/keys/prefs", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Deleted key should not be found: %v", resp.Status)
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	setup()
+	store, err := gost.NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	server := httptest.NewServer(NewHandler(store, auth))
+	defer server.Close()
+
+	resp, _ := request(t, server, "", http.MethodGet, "/users/alice", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Request without credentials should be unauthorized: %v", resp.Status)
+	}
+	resp, _ = request(t, server, "bob", http.MethodGet, "/users/alice", nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Request for another user should be forbidden: %v", resp.Status)
+	}
+	resp, _ = request(t, server, "alice", http.MethodGet, "/users/alice/nothing", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unknown route should not be found: %v", resp.Status)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	setup()
+	store, err := gost.NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	server := httptest.NewServer(NewHandler(store, auth))
+	defer server.Close()
+
+	resp, _ := request(t, server, "alice", http.MethodPut, "/users/alice/objects/docs/note.txt", []byte("hello"))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Failed to put object: %v", resp.Status)
+	}
+	resp, body := request(t, server, "alice", http.MethodGet, "/users/alice/objects/docs/note.txt", nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("Failed to get object: %v, %s", resp.Status, body)
+	}
+	if resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("Content-Disposition") != `attachment; filename=note.txt` {
+		t.Errorf("Object should be served as an attachment: %v", resp.Header)
+	}
+	resp, _ = request(t, server, "bob", http.MethodGet, "/users/bob/objects/docs/note.txt", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Objects should be separate for each user: %v", resp.Status)
+	}
+	resp, _ = request(t, server, "alice", http.MethodDelete, "/users/alice/objects/docs/note.txt", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Failed to delete object: %v", resp.Status)
+	}
+
+	resp, body = request(t, server, "alice", http.MethodPut, "/users/alice/published/avatar.txt", []byte("hello"))
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte(`"location"`)) {
+		t.Errorf("Failed to publish: %v, %s", resp.Status, body)
+	}
+	resp, _ = request(t, server, "alice", http.MethodDelete, "/users/alice/published/avatar.txt", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Failed to unpublish: %v", resp.Status)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	setup()
+	store, err := gost.NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	server := httptest.NewServer(NewHandler(store, auth, WithMaxBodySize(16)))
+	defer server.Close()
+
+	resp, _ := request(t, server, "alice", http.MethodPut, "/users/alice/keys/prefs", []byte(`{"theme": "dark", "size": 12}`))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Key larger than the limit should be rejected: %v", resp.Status)
+	}
+	resp, _ = request(t, server, "alice", http.MethodPut, "/users/alice/published/big.txt", bytes.Repeat([]byte("a"), 17))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("File larger than the limit should be rejected: %v", resp.Status)
+	}
+	resp, _ = request(t, server, "alice", http.MethodPut, "/users/alice/keys/small", []byte(`"dark"`))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Failed to put a key within the limit: %v", resp.Status)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	setup()
+	store, err := gost.NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	server := httptest.NewServer(NewHandler(store, auth))
+	defer server.Close()
+
+	// a unique ID without a backup can't be restored, which isn't a fault of the server
+	err = store.PurgeUser(context.Background(), "carol")
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	resp, _ := request(t, server, "carol", http.MethodPost, "/users/carol/restore", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Restoring without a backup should not be found: %v", resp.Status)
+	}
+
+	for err, status := range map[error]int{
+		gost.ErrConflict: http.StatusConflict,
+		fmt.Errorf("%w: bad checksum", gost.ErrCorruptBackup): http.StatusUnprocessableEntity,
+		errors.New("something else"):                          http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		writeError(w, err)
+		if w.Code != status {
+			t.Errorf("%v should be %d, not %d", err, status, w.Code)
+		}
+	}
+}
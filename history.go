@@ -0,0 +1,247 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// the prefix of the objects that hold the history of keys
+const historyPrefix = gostPrefix + "history/"
+
+// ErrNoSuchVersion is returned by Revert when the key has no version with the given ID
+var ErrNoSuchVersion = errors.New("gost: no such version")
+
+// WithHistory makes the store keep the history of every key, so earlier
+// values can be read with History and GetAt, and brought back with Revert
+func WithHistory() Option {
+	return func(s *Store) {
+		s.history = true
+	}
+}
+
+// Version is a value a key had at some point
+type Version struct {
+	// identifies the version for Revert
+	ID string
+	// when the key got the value, zero for the value the key had when its
+	// history started
+	Time  time.Time
+	Actor string
+	// put, delete, delete-all, restore, incr, append, compare-and-swap or revert,
+	// empty for the value the key had when its history started
+	Op string
+	// the value, nil if the key was deleted
+	Value   any
+	Deleted bool
+}
+
+// a change to a key, as it's stored in the history
+type historyRecord struct {
+	Codec     int
+	Time      time.Time
+	Actor     string
+	Op        string
+	Old       envelope
+	OldExists bool
+	New       envelope
+	NewExists bool
+}
+
+// the suffix of the ID of the value a key had before a change
+const beforeSuffix = "^"
+
+// get the prefix of the history of a key
+func historyObjects(uid string, key string) string {
+	return historyPrefix + encode(uid) + "/" + encode(key) + "/"
+}
+
+// write the history of the keys that changed between the old and new data
+// for a unique ID, if the store keeps history
+// Like audit records, failing to write the history is only logged
+func (s *Store) recordHistory(ctx context.Context, op string, uid string, old map[string]any, new map[string]any) {
+	if !s.history {
+		return
+	}
+	now := time.Now().UTC()
+	for key, value := range old {
+		newValue, exists := new[key]
+		if !exists || hashValue(newValue) != hashValue(value) {
+			s.writeHistory(ctx, now, op, uid, key, value, true, newValue, exists)
+		}
+	}
+	for key, value := range new {
+		if _, exists := old[key]; !exists {
+			s.writeHistory(ctx, now, op, uid, key, nil, false, value, true)
+		}
+	}
+}
+
+// write a change to a key into its history
+// Records are never overwritten, each one gets an object of its own
+func (s *Store) writeHistory(ctx context.Context, now time.Time, op string, uid string, key string, old any, oldExists bool, new any, newExists bool) {
+	rec := historyRecord{
+		Codec:     codecVersion,
+		Time:      now,
+		Actor:     ActorFromContext(ctx),
+		Op:        op,
+		OldExists: oldExists,
+		NewExists: newExists,
+	}
+	var err error
+	rec.Old, err = encodeValue(old)
+	if err == nil {
+		rec.New, err = encodeValue(new)
+	}
+	if err != nil {
+		log.Println("Cannot write history:", err)
+		return
+	}
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(rec)
+	if err != nil {
+		log.Println("Cannot encode gob:", err)
+		return
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	objectName := historyObjects(uid, key) + now.Format(auditTimeFormat) + "-" + hex.EncodeToString(suffix) + ".gob"
+	_, err = s.upload(ifNoneMatch(ctx), objectName, buf.Bytes(),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		log.Println("Cannot write history:", err)
+	}
+}
+
+// read the history of a key, oldest change first, with the ID of each change
+func (s *Store) readHistory(ctx context.Context, uid string, key string) (ids []string, records []historyRecord, err error) {
+	err = ValidateKey(key)
+	if err != nil {
+		return
+	}
+	err = ValidateUID(uid)
+	if err != nil {
+		return
+	}
+	prefix := historyObjects(uid, key)
+	for info := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list history:", err)
+			return
+		}
+		var obj objectReader
+		obj, err = s.openObject(ctx, info.Key, minio.GetObjectOptions{})
+		if err != nil {
+			log.Println("Cannot get history:", err)
+			return
+		}
+		var rec historyRecord
+		err = gob.NewDecoder(obj).Decode(&rec)
+		obj.Close()
+		if err != nil {
+			log.Println("Cannot decode history:", err)
+			return
+		}
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(info.Key, prefix), ".gob"))
+		records = append(records, rec)
+	}
+	return
+}
+
+// History returns the values a key has had, oldest first
+// The history starts from the first change made after the store started
+// keeping history, and includes the value the key had before that change
+func (s *Store) History(ctx context.Context, uid string, key string) (versions []Version, err error) {
+	ctx, done := s.operation(ctx, "History")
+	defer done(&err)
+	ids, records, err := s.readHistory(ctx, uid, key)
+	if err != nil {
+		return
+	}
+	for i, rec := range records {
+		if i == 0 && rec.OldExists {
+			var value any
+			value, _, err = decodeValue(rec.Old)
+			if err != nil {
+				err = inObject(err, historyObjects(uid, key)+ids[i])
+				return
+			}
+			versions = append(versions, Version{ID: ids[i] + beforeSuffix, Value: value})
+		}
+		version := Version{ID: ids[i], Time: rec.Time, Actor: rec.Actor, Op: rec.Op, Deleted: !rec.NewExists}
+		version.Value, _, err = decodeValue(rec.New)
+		if err != nil {
+			err = inObject(err, historyObjects(uid, key)+ids[i])
+			return
+		}
+		versions = append(versions, version)
+	}
+	return
+}
+
+// GetAt returns the value a key had at the given time, nil if it didn't exist
+// Times before the history of the key started give the value it had when it started
+func (s *Store) GetAt(ctx context.Context, uid string, key string, t time.Time) (data any, err error) {
+	ctx, done := s.operation(ctx, "GetAt")
+	defer done(&err)
+	_, records, err := s.readHistory(ctx, uid, key)
+	if err != nil {
+		return
+	}
+	for _, rec := range records {
+		if rec.Time.After(t) {
+			data, _, err = decodeValue(rec.Old)
+			return
+		}
+	}
+	// nothing has changed since then
+	return s.Get(ctx, uid, key)
+}
+
+// Revert sets a key back to the value it had in a version from its history
+// If the key was deleted in that version, it's deleted again. The revert is
+// a change of its own, so it can be reverted too
+func (s *Store) Revert(ctx context.Context, uid string, key string, version string) (err error) {
+	ctx, done := s.operation(ctx, "Revert")
+	defer done(&err)
+	ids, records, err := s.readHistory(ctx, uid, key)
+	if err != nil {
+		return
+	}
+	var value any
+	var exists, found bool
+	for i, rec := range records {
+		switch version {
+		case ids[i]:
+			value, _, err = decodeValue(rec.New)
+			exists, found = rec.NewExists, true
+		case ids[i] + beforeSuffix:
+			value, _, err = decodeValue(rec.Old)
+			exists, found = rec.OldExists, true
+		}
+		if found || err != nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	if !found {
+		return ErrNoSuchVersion
+	}
+	if !exists {
+		return s.deleteKey(ctx, "revert", uid, key)
+	}
+	return s.modifyKey(ctx, "revert", uid, key, func(current any, exists bool) (any, error) {
+		return value, nil
+	})
+}
@@ -0,0 +1,83 @@
+package gost
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// start without the history kept by earlier runs
+	err = store.PurgeUser(ctx, "history-test")
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	// the value from before history was kept
+	err = store.Put(ctx, "history-test", "theme", "light")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	store, err = NewStore(key, secret, endpoint, useSSL, region, bucket, WithHistory())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	err = store.Put(ctx, "history-test", "theme", "dark")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	err = store.Delete(ctx, "history-test", "theme")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+
+	versions, err := store.History(ctx, "history-test", "theme")
+	if err != nil {
+		t.Errorf("Failed to get the history: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Wrong number of versions: %+v", versions)
+	}
+	if versions[0].Value != "light" || versions[1].Value != "dark" || !versions[2].Deleted {
+		t.Errorf("Wrong versions: %+v", versions)
+	}
+
+	value, err := store.GetAt(ctx, "history-test", "theme", between)
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "dark" {
+		t.Errorf("Failed to get the value at the time: %v", value)
+	}
+	value, err = store.GetAt(ctx, "history-test", "theme", time.Time{})
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "light" {
+		t.Errorf("Failed to get the value from before the history: %v", value)
+	}
+
+	err = store.Revert(ctx, "history-test", "theme", versions[0].ID)
+	if err != nil {
+		t.Errorf("Failed to revert: %v", err)
+	}
+	value, err = store.Get(ctx, "history-test", "theme")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "light" {
+		t.Errorf("Failed to revert: %v", value)
+	}
+	err = store.Revert(ctx, "history-test", "theme", "no-such-version")
+	if err != ErrNoSuchVersion {
+		t.Errorf("Reverting to a missing version should fail: %v", err)
+	}
+}
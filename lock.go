@@ -0,0 +1,235 @@
+package gost
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// the prefix of the objects that hold locks
+const locksPrefix = gostPrefix + "locks/"
+
+// ErrLocked is returned by TryLock when the lock is held by someone else
+var ErrLocked = errors.New("gost: locked")
+
+// ErrLeaseLost is returned when renewing or releasing a lease that has
+// expired and been taken over by someone else
+var ErrLeaseLost = errors.New("gost: lease lost")
+
+// the longest to wait between tries to take a lock
+const maxLockWait = time.Second
+
+// the lease as it's stored in the lock object
+type leaseRecord struct {
+	Owner   string    `json:"owner"`
+	Token   int64     `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lease is a lock held until it expires or is released
+// Each time a lock is taken it gets a fencing token that is larger than the
+// one before, so anything protected by the lock can reject writes from a
+// holder whose lease expired without it noticing
+type Lease struct {
+	store  *Store
+	name   string
+	ttl    time.Duration
+	owner  string
+	token  int64
+	mutex  sync.Mutex
+	etag   string
+	expiry time.Time
+}
+
+// get the name of the object for a lock
+func lockObject(name string) string {
+	return locksPrefix + encode(name)
+}
+
+// Lock takes the named lock for ttl, waiting until it's released or expires
+// if someone else holds it, or until the context is done
+// The lease must be renewed before ttl is up to keep holding the lock.
+// Expiry is worked out with the clock of the one taking the lock, so clocks
+// of the machines using the lock should be kept in sync
+func (s *Store) Lock(ctx context.Context, name string, ttl time.Duration) (lease *Lease, err error) {
+	ctx, done := s.longOperation(ctx, "Lock")
+	defer done(&err)
+	for attempt := 0; ; attempt++ {
+		var expires time.Time
+		lease, expires, err = s.tryLock(ctx, name, ttl)
+		if err != ErrLocked {
+			return
+		}
+		wait := backoff(attempt)
+		if wait > maxLockWait {
+			wait = maxLockWait
+		}
+		if until := time.Until(expires); until > 0 && until < wait {
+			wait = until
+		}
+		err = sleep(ctx, wait)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// TryLock takes the named lock for ttl, returning ErrLocked straight away if
+// someone else holds it
+func (s *Store) TryLock(ctx context.Context, name string, ttl time.Duration) (lease *Lease, err error) {
+	ctx, done := s.operation(ctx, "TryLock")
+	defer done(&err)
+	lease, _, err = s.tryLock(ctx, name, ttl)
+	return
+}
+
+// take the lock if it's free, otherwise return ErrLocked and when the lease holding it expires
+func (s *Store) tryLock(ctx context.Context, name string, ttl time.Duration) (lease *Lease, expires time.Time, err error) {
+	err = validateText("lock", name, MaxKeyLength)
+	if err != nil {
+		return
+	}
+	if ttl <= 0 {
+		err = errors.New("gost: lock ttl must be positive")
+		return
+	}
+	current, etag, err := s.readLease(ctx, name)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if current.Expires.After(now) {
+		return nil, current.Expires, ErrLocked
+	}
+	lease = &Lease{
+		store: s,
+		name:  name,
+		ttl:   ttl,
+		owner: newOwner(),
+		token: current.Token + 1,
+	}
+	// take over the expired or released lease only if no one else got to it first
+	if etag == "" {
+		ctx = ifNoneMatch(ctx)
+	} else {
+		ctx = ifMatch(ctx, etag)
+	}
+	err = lease.write(ctx, now.Add(ttl))
+	if isPreconditionFailed(err) {
+		return nil, time.Time{}, ErrLocked
+	}
+	if err != nil {
+		lease = nil
+	}
+	return
+}
+
+// read the lease stored in the lock object, and the object's ETag
+// If the object doesn't exist, the lease is empty and so is the ETag
+func (s *Store) readLease(ctx context.Context, name string) (record leaseRecord, etag string, err error) {
+	obj, err := s.openObject(ctx, lockObject(name), minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get lock:", err)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		if isNoSuchKey(err) {
+			err = nil
+			return
+		}
+		log.Println("Cannot get lock:", err)
+		return
+	}
+	b, err := io.ReadAll(obj)
+	if err != nil {
+		log.Println("Cannot read lock:", err)
+		return
+	}
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		log.Println("Cannot decode lock:", err)
+		return
+	}
+	etag = info.ETag
+	return
+}
+
+// a random ID for the holder of a lease
+func newOwner() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// write the lease to the lock object with the conditions in the context
+func (l *Lease) write(ctx context.Context, expires time.Time) (err error) {
+	b, err := json.Marshal(leaseRecord{Owner: l.owner, Token: l.token, Expires: expires})
+	if err != nil {
+		return
+	}
+	info, err := l.store.upload(ctx, lockObject(l.name), b, minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		if !isPreconditionFailed(err) {
+			log.Println("Cannot put lock:", err)
+		}
+		return
+	}
+	l.etag, l.expiry = info.ETag, expires
+	return
+}
+
+// Name returns the name of the lock
+func (l *Lease) Name() string {
+	return l.name
+}
+
+// Token returns the fencing token of the lease
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Expires returns when the lease expires unless it's renewed
+func (l *Lease) Expires() time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.expiry
+}
+
+// Renew extends the lease for the ttl it was taken with, counting from now
+// It returns ErrLeaseLost if the lease expired and someone else took the lock
+func (l *Lease) Renew(ctx context.Context) (err error) {
+	ctx, done := l.store.operation(ctx, "Lease.Renew")
+	defer done(&err)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err = l.write(ifMatch(ctx, l.etag), time.Now().Add(l.ttl))
+	if isPreconditionFailed(err) {
+		err = ErrLeaseLost
+	}
+	return
+}
+
+// Release gives up the lock so someone else can take it straight away
+// The lock object is kept, so the next lease gets a larger fencing token.
+// It returns ErrLeaseLost if the lease expired and someone else took the lock
+func (l *Lease) Release(ctx context.Context) (err error) {
+	ctx, done := l.store.operation(ctx, "Lease.Release")
+	defer done(&err)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err = l.write(ifMatch(ctx, l.etag), time.Time{})
+	if isPreconditionFailed(err) {
+		err = ErrLeaseLost
+	}
+	return
+}
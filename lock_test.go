@@ -0,0 +1,85 @@
+package gost
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	lease, err := store.TryLock(ctx, "lock-test", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	_, err = store.TryLock(ctx, "lock-test", time.Minute)
+	if err != ErrLocked {
+		t.Errorf("Lock should be held: %v", err)
+	}
+	err = lease.Renew(ctx)
+	if err != nil {
+		t.Errorf("Failed to renew: %v", err)
+	}
+
+	// Lock should wait for the lease to be released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		err := lease.Release(ctx)
+		if err != nil {
+			t.Errorf("Failed to release: %v", err)
+		}
+	}()
+	next, err := store.Lock(ctx, "lock-test", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	if next.Token() <= lease.Token() {
+		t.Errorf("Fencing token should increase: %v, %v", lease.Token(), next.Token())
+	}
+	err = next.Release(ctx)
+	if err != nil {
+		t.Errorf("Failed to release: %v", err)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	lease, err := store.TryLock(ctx, "lock-expiry-test", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	next, err := store.TryLock(ctx, "lock-expiry-test", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to take over an expired lease: %v", err)
+	}
+	err = lease.Renew(ctx)
+	if err != ErrLeaseLost {
+		t.Errorf("Renewing a lease that was taken over should fail: %v", err)
+	}
+	err = next.Release(ctx)
+	if err != nil {
+		t.Errorf("Failed to release: %v", err)
+	}
+
+	cancelled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = store.TryLock(ctx, "lock-expiry-test", time.Minute)
+	if err != nil {
+		t.Errorf("Failed to lock: %v", err)
+	}
+	_, err = store.Lock(cancelled, "lock-expiry-test", time.Minute)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Lock should give up when the context is done: %v", err)
+	}
+}
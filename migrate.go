@@ -0,0 +1,199 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// MigrateStats counts what MigrateAll did
+type MigrateStats struct {
+	// objects that were read
+	Scanned int
+	// objects that were written again in the current format
+	Migrated int
+	// objects that aren't stored by gost, like streams and published files
+	Skipped int
+}
+
+// MigrateAll reads every map and object stored in the bucket and writes back
+// the ones stored in an older format or holding values of older versions of
+// their types, so they no longer need to be upgraded when they are read
+// Objects that can't be decoded, like streams, are skipped. Backup
+// generations are read but kept as they were taken
+func (s *Store) MigrateAll(ctx context.Context) (stats MigrateStats, err error) {
+	ctx, done := s.longOperation(ctx, "MigrateAll")
+	defer done(&err)
+	stats.Skipped, err = s.eachStored(ctx, func(st stored) (err error) {
+		if st.err != nil {
+			return st.err
+		}
+		stats.Scanned++
+		if !st.stale {
+			return
+		}
+		// data that has changed since it was read was written by this version
+		// of gost, so it doesn't need migrating
+		writeCtx := ifMatch(ctx, st.etag)
+		if st.isMap {
+			err = s.writeMap(writeCtx, st.name, st.data)
+		} else {
+			err = s.putObject(writeCtx, st.name, st.obj, PutOptions{
+				ContentType: st.info.ContentType,
+				Metadata:    st.info.Metadata,
+				Tags:        st.info.Tags,
+			})
+		}
+		if isPreconditionFailed(err) {
+			return nil
+		}
+		if err == nil {
+			stats.Migrated++
+		}
+		return
+	})
+	return
+}
+
+// CheckTypes reads every map and object stored in the bucket and checks
+// that the types of all the values in them are registered
+// It's meant to be called when starting up, after registering types. If any
+// types aren't registered, the error is an UnregisteredTypeError listing them
+func (s *Store) CheckTypes(ctx context.Context) (err error) {
+	ctx, done := s.longOperation(ctx, "CheckTypes")
+	defer done(&err)
+	var unregistered *UnregisteredTypeError
+	_, err = s.eachStored(ctx, func(st stored) error {
+		var u *UnregisteredTypeError
+		if errors.As(st.err, &u) {
+			unregistered = unregistered.add(u.Types...)
+			return nil
+		}
+		return st.err
+	})
+	if err == nil && unregistered != nil {
+		err = unregistered
+	}
+	return
+}
+
+// a map or object stored by gost, decoded
+type stored struct {
+	name  string
+	isMap bool
+	data  map[string]any
+	obj   any
+	// the content type, metadata and tags of an object
+	info ObjectInfo
+	// the ETag of the object the map or object was read from
+	etag  string
+	stale bool
+	// the error decoding the map or object
+	err error
+}
+
+// decode every map and object stored by gost in the bucket and call fn with
+// each of them, stopping at the first error fn returns
+// Objects that aren't stored by gost, like streams and published files, are
+// counted in skipped
+func (s *Store) eachStored(ctx context.Context, fn func(st stored) error) (skipped int, err error) {
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		var st stored
+		var ok bool
+		switch {
+		case strings.HasPrefix(obj.Key, "public/"), strings.HasPrefix(obj.Key, gostPrefix):
+		case isGeneration(obj.Key):
+			st, err = s.readStoredGeneration(ctx, obj.Key)
+			ok = true
+		case strings.HasPrefix(obj.Key, "data/"), strings.HasPrefix(obj.Key, "backup/"):
+			st, err = s.readStoredMap(ctx, obj.Key)
+			ok = true
+		default:
+			st, ok, err = s.readStoredObject(ctx, obj.Key)
+		}
+		if err != nil {
+			return
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		err = fn(st)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// read and decode a map
+func (s *Store) readStoredMap(ctx context.Context, objectName string) (st stored, err error) {
+	obj, err := s.openObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	st = stored{name: objectName, isMap: true, etag: info.ETag}
+	st.data, st.stale, st.err = decodeMap(obj)
+	st.err = inObject(st.err, objectName)
+	return
+}
+
+// read and decode a backup generation
+// Generations are kept as they were taken, so they are never stale
+func (s *Store) readStoredGeneration(ctx context.Context, objectName string) (st stored, err error) {
+	st = stored{name: objectName, isMap: true}
+	_, st.data, _, st.err = s.readGeneration(ctx, objectName, true)
+	return
+}
+
+// the largest object without the codec metadata that's read to find out if
+// it's gob encoded, so streams like images and PDFs aren't downloaded
+const maxUnmarkedObjectSize = 1 << 20
+
+// read and decode an object, ok is false if it's not stored by gost
+// Objects without the codec metadata might have been stored before it was
+// added, so they are only left out if they aren't gob encoded, or are too big
+// to be worth reading to find out
+func (s *Store) readStoredObject(ctx context.Context, objectName string) (st stored, ok bool, err error) {
+	info, err := s.stat(ctx, objectName)
+	if err != nil {
+		return
+	}
+	_, encoded := info.Metadata[codecMeta]
+	if !encoded && (info.ContentType != "application/octet-stream" || info.Size > maxUnmarkedObjectSize) {
+		return
+	}
+	// make sure the data read is from the same object that was stat'ed
+	options := minio.GetObjectOptions{}
+	err = options.SetMatchETag(info.ETag)
+	if err != nil {
+		log.Println("Cannot set etag:", err)
+		return
+	}
+	obj, err := s.openObject(ctx, objectName, options)
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	defer obj.Close()
+	st = stored{name: objectName, info: info, etag: info.ETag}
+	st.obj, st.stale, st.err = decodeObject(obj)
+	st.err = inObject(st.err, objectName)
+	ok = encoded || st.err == nil || errors.Is(st.err, ErrUnregisteredType)
+	return
+}
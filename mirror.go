@@ -0,0 +1,225 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// user metadata with the ETag of the object a mirrored object was copied from
+const sourceETagMeta = "Gost-Source-Etag"
+
+// MirrorOptions are the options for mirroring one store to another
+type MirrorOptions struct {
+	// Delete objects in the destination that are not in the source
+	Delete bool
+	// Keep mirroring until the context is cancelled, checking the source for
+	// changes at this interval. If it's zero, the source is mirrored once
+	Interval time.Duration
+	// Called after each pass over the source, if it's not nil
+	Progress func(MirrorStats)
+}
+
+// MirrorStats counts what was done in a pass over the source
+type MirrorStats struct {
+	Copied    int
+	Deleted   int
+	Unchanged int
+}
+
+// Mirror copies every object from the source store to the destination store
+// Only objects that have changed since the last time they were mirrored are
+// copied, which is worked out by comparing ETags
+// If opts.Interval is set, Mirror keeps mirroring until the context is
+// cancelled and then returns the context's error
+func Mirror(ctx context.Context, src *Store, dst *Store, opts MirrorOptions) (stats MirrorStats, err error) {
+	defer contextError(ctx, &err)
+	if src.client.EndpointURL().String() == dst.client.EndpointURL().String() && src.bucket == dst.bucket {
+		err = errors.New("cannot mirror a store to itself")
+		return
+	}
+	for {
+		passCtx, done := src.longOperation(ctx, "Mirror")
+		stats, err = mirror(passCtx, src, dst, opts)
+		done(&err)
+		if err != nil {
+			return
+		}
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+		if opts.Interval == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// list the ETags of all objects in the store
+func (s *Store) etags(ctx context.Context) (etags map[string]string, err error) {
+	etags = make(map[string]string)
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		etags[obj.Key] = strings.Trim(obj.ETag, `"`)
+	}
+	return
+}
+
+// make a single pass over the source, copying objects that have changed
+func mirror(ctx context.Context, src *Store, dst *Store, opts MirrorOptions) (stats MirrorStats, err error) {
+	srcETags, err := src.etags(ctx)
+	if err != nil {
+		return
+	}
+	dstETags, err := dst.etags(ctx)
+	if err != nil {
+		return
+	}
+	// the references copied and deleted are counted at the end of the pass,
+	// as blobs can be copied after the references to them
+	changes := make(refChanges)
+	defer func() {
+		refsErr := dst.applyRefs(ctx, changes)
+		if err == nil {
+			err = refsErr
+		}
+	}()
+	for objectName, etag := range srcETags {
+		var changed bool
+		changed, err = mirrorChanged(ctx, dst, objectName, etag, dstETags)
+		if err != nil {
+			return
+		}
+		if !changed {
+			stats.Unchanged++
+			continue
+		}
+		err = mirrorObject(ctx, src, dst, objectName, changes)
+		if err != nil {
+			return
+		}
+		stats.Copied++
+	}
+	if opts.Delete {
+		for objectName := range dstETags {
+			if _, ok := srcETags[objectName]; ok {
+				continue
+			}
+			err = removeMirrored(ctx, dst, objectName, changes)
+			if err != nil {
+				log.Println("Cannot remove object:", err)
+				return
+			}
+			stats.Deleted++
+		}
+	}
+	return
+}
+
+// check if the object in the source has changed since it was last mirrored
+func mirrorChanged(ctx context.Context, dst *Store, objectName string, etag string, dstETags map[string]string) (changed bool, err error) {
+	dstETag, ok := dstETags[objectName]
+	if !ok {
+		return true, nil
+	}
+	// blobs with the same name have the same content, and only their reference
+	// counts, which are kept for the destination's own references, differ
+	if isBlob(objectName) {
+		return false, nil
+	}
+	// objects uploaded in parts have different ETags even if they are the same,
+	// so check the ETag of the source the object was copied from as well
+	if dstETag == etag {
+		return false, nil
+	}
+	info, err := dst.statObject(ctx, objectName)
+	if err != nil {
+		log.Println("Cannot stat object:", err)
+		return
+	}
+	return info.UserMetadata[sourceETagMeta] != etag, nil
+}
+
+// copy an object from the source to the destination, counting the
+// references it replaces and adds in changes
+func mirrorObject(ctx context.Context, src *Store, dst *Store, objectName string, changes refChanges) (err error) {
+	info, err := src.stat(ctx, objectName)
+	if err != nil {
+		return
+	}
+	var previous minio.ObjectInfo
+	if isReference(objectName) {
+		previous, err = dst.statObject(ctx, objectName)
+		if err != nil && !isNoSuchKey(err) {
+			log.Println("Cannot stat object:", err)
+			return
+		}
+	}
+	options := minio.GetObjectOptions{}
+	err = options.SetMatchETag(info.ETag)
+	if err != nil {
+		log.Println("Cannot set etag:", err)
+		return
+	}
+	obj, err := src.openObject(ctx, objectName, options)
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	defer obj.Close()
+	metadata := make(map[string]string)
+	for k, v := range info.Metadata {
+		metadata[k] = v
+	}
+	metadata[sourceETagMeta] = strings.Trim(info.ETag, `"`)
+	opts := PutOptions{
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		Metadata:    metadata,
+		Tags:        info.Tags,
+	}
+	if isBlob(objectName) {
+		_, err = dst.putBlob(ctx, objectName, obj, opts)
+		return
+	}
+	_, err = dst.putStream(ctx, objectName, obj, opts)
+	if err == nil && isReference(objectName) {
+		changes.replace(refBlob(previous.UserMetadata), refBlob(metadata))
+	}
+	return
+}
+
+// remove an object from the destination that's not in the source, counting
+// the reference it removes in changes
+func removeMirrored(ctx context.Context, dst *Store, objectName string, changes refChanges) (err error) {
+	var previous minio.ObjectInfo
+	if isReference(objectName) {
+		previous, err = dst.statObject(ctx, objectName)
+		if isNoSuchKey(err) {
+			return nil
+		}
+		if err != nil {
+			log.Println("Cannot stat object:", err)
+			return
+		}
+	}
+	err = dst.removeObject(ctx, objectName)
+	if err != nil {
+		return
+	}
+	changes.replace(refBlob(previous.UserMetadata), "")
+	return
+}
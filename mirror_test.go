@@ -0,0 +1,136 @@
+package gost
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	setup()
+	src, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-mirror-src")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	dst, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-mirror-dst")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// start without the data mirrored by earlier runs
+	for _, store := range []*Store{src, dst} {
+		err = store.PurgeUser(ctx, "sausheong")
+		if err != nil {
+			t.Errorf("Failed to purge: %v", err)
+		}
+	}
+	err = src.Put(ctx, "sausheong", "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = dst.PutObject(ctx, "stale", "not in the source")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+
+	stats, err := Mirror(ctx, src, dst, MirrorOptions{Delete: true})
+	if err != nil {
+		t.Errorf("Failed to mirror: %v", err)
+	}
+	if stats.Copied != 1 || stats.Deleted != 1 {
+		t.Errorf("Failed to mirror the right objects: %+v", stats)
+	}
+	data, err := dst.Get(ctx, "sausheong", "123")
+	if err != nil || data != "hello world!" {
+		t.Errorf("Failed to get mirrored data: %v, %v", data, err)
+	}
+
+	// nothing has changed, so nothing should be copied
+	stats, err = Mirror(ctx, src, dst, MirrorOptions{})
+	if err != nil {
+		t.Errorf("Failed to mirror: %v", err)
+	}
+	if stats.Copied != 0 || stats.Unchanged != 1 {
+		t.Errorf("Unchanged objects should not be copied: %+v", stats)
+	}
+
+	_, err = Mirror(ctx, src, src, MirrorOptions{})
+	if err == nil {
+		t.Errorf("Mirroring a store to itself should fail")
+	}
+}
+
+func TestMirrorContinuously(t *testing.T) {
+	setup()
+	src, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-mirror-src")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	dst, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-mirror-dst")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	passes := 0
+	_, err = Mirror(ctx, src, dst, MirrorOptions{
+		Interval: 100 * time.Millisecond,
+		Progress: func(stats MirrorStats) {
+			passes++
+			if passes == 2 {
+				cancel()
+			}
+		},
+	})
+	if err != context.Canceled {
+		t.Errorf("Mirroring should stop when the context is cancelled: %v", err)
+	}
+	if passes != 2 {
+		t.Errorf("Failed to mirror continuously: %v passes", passes)
+	}
+}
+
+func TestMirrorRefs(t *testing.T) {
+	setup()
+	src, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-refs-mirror-src")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	dst, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-refs-mirror-dst")
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	// the same content is published in both stores, under different names
+	content := []byte("shared at " + time.Now().String())
+	hash, _, err := dst.PublishContent(ctx, "refs-dst.txt", "text/plain", content)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	_, _, err = src.PublishContent(ctx, "refs-src.txt", "text/plain", content)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	_, err = Mirror(ctx, src, dst, MirrorOptions{})
+	if err != nil {
+		t.Errorf("Failed to mirror: %v", err)
+	}
+
+	// the mirrored reference is counted along with the one already there
+	err = dst.Unpublish(ctx, "refs-dst.txt")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = dst.statObject(ctx, blob(hash, "text/plain"))
+	if err != nil {
+		t.Errorf("Blob that's still referred to should be kept: %v", err)
+	}
+	err = dst.Unpublish(ctx, "refs-src.txt")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = dst.statObject(ctx, blob(hash, "text/plain"))
+	if !isNoSuchKey(err) {
+		t.Errorf("Blob that nothing refers to should be removed: %v", err)
+	}
+}
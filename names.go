@@ -0,0 +1,141 @@
+package gost
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the length of names, in bytes
+const (
+	MaxUIDLength        = 256
+	MaxKeyLength        = 256
+	MaxObjectNameLength = 512
+)
+
+// ErrInvalidName is matched by errors.Is for every NameError
+var ErrInvalidName = errors.New("invalid name")
+
+// NameError is returned when a unique ID, key, object name or collection name is invalid
+type NameError struct {
+	Kind   string // uid, key, object, filename, collection or lock
+	Name   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("gost: invalid %s %q: %s", e.Kind, e.Name, e.Reason)
+}
+
+func (e *NameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+// the prefix of the objects gost keeps for itself, like locks, audit records
+// and history, so they don't take names that raw objects could have
+const gostPrefix = "gost/"
+
+// prefixes used by gost itself, raw objects can't be put in them
+var reservedPrefixes = []string{"data/", "backup/", "public/", collectionPrefix, gostPrefix}
+
+// check if the object name is in one of the prefixes used by gost
+func isReserved(name string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check that the name is not empty, not too long, and is printable UTF-8
+func validateText(kind string, name string, max int) (err error) {
+	if name == "" {
+		return &NameError{kind, name, "must not be empty"}
+	}
+	if len(name) > max {
+		return &NameError{kind, name, fmt.Sprintf("must not be longer than %d bytes", max)}
+	}
+	if !utf8.ValidString(name) {
+		return &NameError{kind, name, "must be valid UTF-8"}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return &NameError{kind, name, fmt.Sprintf("must not contain control character %q", r)}
+		}
+	}
+	return
+}
+
+// ValidateUID checks that a unique ID can be used with Put, Get, Backup and so on
+// Unique IDs are encoded before they are used in object names, so apart from
+// control characters anything can be used, up to MaxUIDLength bytes
+func ValidateUID(uid string) error {
+	return validateText("uid", uid, MaxUIDLength)
+}
+
+// ValidateKey checks that a key can be used with Put, Get and Delete
+// Apart from control characters anything can be used, up to MaxKeyLength bytes
+func ValidateKey(key string) error {
+	return validateText("key", key, MaxKeyLength)
+}
+
+// check that the name can be used as (part of) an object name as-is
+func validatePath(kind string, name string) (err error) {
+	err = validateText(kind, name, MaxObjectNameLength)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return &NameError{kind, name, "must not have empty path segments"}
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." {
+			return &NameError{kind, name, "must not have relative path segments"}
+		}
+	}
+	return
+}
+
+// ValidateObjectName checks that a name can be used with PutObject, PutStream
+// and the other functions for raw objects
+// Object names are used as-is, so they can be made of path segments separated
+// by '/', but can't have empty, "." or ".." segments, can't have control
+// characters, must be up to MaxObjectNameLength bytes and can't start with
+// the data/, backup/, public/, objects/ or gost/ prefixes used by gost
+func ValidateObjectName(name string) (err error) {
+	err = validatePath("object", name)
+	if err != nil {
+		return
+	}
+	if isReserved(name) {
+		return &NameError{"object", name, "must not start with a prefix reserved by gost"}
+	}
+	return
+}
+
+// encode a unique ID or key so it's safe to use in object names
+func encode(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// decode a unique ID or key from an object name, in either the current or the legacy encoding
+func decode(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.StdEncoding.DecodeString(s)
+	}
+	return string(b), err
+}
+
+// get the name of the object to store
+func name(uid string) string {
+	return "data/" + encode(uid) + ".gob"
+}
+
+// get the name of the object used before unique IDs were URL-safe encoded
+func legacyName(uid string) string {
+	return "data/" + base64.StdEncoding.EncodeToString([]byte(uid)) + ".gob"
+}
@@ -0,0 +1,106 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestValidateNames(t *testing.T) {
+	valid := []string{"sausheong", "sau.sheong+gost@example.com", "日本語", strings.Repeat("a", MaxUIDLength)}
+	for _, uid := range valid {
+		if err := ValidateUID(uid); err != nil {
+			t.Errorf("UID %q should be valid: %v", uid, err)
+		}
+	}
+	invalid := []string{"", "new\nline", "\x7f", "\xff\xfe", strings.Repeat("a", MaxUIDLength+1)}
+	for _, uid := range invalid {
+		err := ValidateUID(uid)
+		if !errors.Is(err, ErrInvalidName) {
+			t.Errorf("UID %q should be invalid: %v", uid, err)
+		}
+		var nameErr *NameError
+		if !errors.As(err, &nameErr) || nameErr.Kind != "uid" {
+			t.Errorf("Error should be a NameError for a uid: %v", err)
+		}
+	}
+}
+
+func TestValidateObjectName(t *testing.T) {
+	// names like the ones gost keeps for itself are fine outside gost/
+	valid := []string{"leaderboard", "reports/2022/q1.pdf", "a.b..c", "locks/x", "audit/x", "history/2023.gob", "manifests/x", "owners/x"}
+	for _, name := range valid {
+		if err := ValidateObjectName(name); err != nil {
+			t.Errorf("Object name %q should be valid: %v", name, err)
+		}
+	}
+	invalid := []string{"", "/root", "dir/", "a//b", "a/../b", "./a", "data/Zm9v.gob", "backup/x", "public/x", "objects/x/y", "gost/locks/x"}
+	for _, name := range invalid {
+		if err := ValidateObjectName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Object name %q should be invalid: %v", name, err)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	// the standard encoding of this unique ID has '/' and '+' in it
+	uid := "sau?sheong>>@example.com"
+	if strings.ContainsAny(strings.TrimPrefix(name(uid), "data/"), "/+=") {
+		t.Errorf("Name should be URL-safe: %v", name(uid))
+	}
+	if !strings.Contains(legacyName(uid), "/") {
+		t.Errorf("Legacy name should have the standard encoding: %v", legacyName(uid))
+	}
+	decoded, err := decode(strings.TrimSuffix(strings.TrimPrefix(name(uid), "data/"), ".gob"))
+	if err != nil || decoded != uid {
+		t.Errorf("Failed to decode name: %v, %v", decoded, err)
+	}
+}
+
+func TestLegacyName(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	uid := "sau?sheong>>@example.com"
+
+	// write data the way it was written before names were URL-safe
+	err = store.writeMap(ctx, legacyName(uid), map[string]any{"legacy": "old data"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	data, err := store.Get(ctx, uid, "legacy")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if data != "old data" {
+		t.Errorf("Failed to get legacy data: %v", data)
+	}
+
+	// writing moves the data to the new name
+	err = store.Put(ctx, uid, "new", "new data")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = store.client.StatObject(ctx, store.bucket, legacyName(uid), minio.StatObjectOptions{})
+	if !isNoSuchKey(err) {
+		t.Errorf("Legacy object should have been removed: %v", err)
+	}
+	all, err := store.GetAll(ctx, uid)
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if all["legacy"] != "old data" || all["new"] != "new data" {
+		t.Errorf("Failed to get all the data: %v", all)
+	}
+
+	err = store.Put(ctx, uid, "", "no key")
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Empty key should be rejected: %v", err)
+	}
+}
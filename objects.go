@@ -3,48 +3,103 @@ package gost
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"log"
 
 	"github.com/minio/minio-go/v7"
 )
 
 // Put an object in the database, with an associated a unique ID
-func (s *Store) PutObject(ctx context.Context, uid string, obj any) (err error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(&obj)
+// Options can be given to set the content type, user metadata and tags of the object
+func (s *Store) PutObject(ctx context.Context, uid string, obj any, opts ...PutOptions) (err error) {
+	ctx, done := s.operation(ctx, "PutObject")
+	defer done(&err)
+	err = ValidateObjectName(uid)
 	if err != nil {
-		log.Println("Cannot encode gob:", err)
 		return
 	}
-	s.client.PutObject(ctx, s.bucket, uid, &buf, int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return s.putObject(ctx, uid, obj, opts...)
+}
+
+func (s *Store) putObject(ctx context.Context, objectName string, obj any, opts ...PutOptions) (err error) {
+	buf, err := encodeObject(obj)
 	if err != nil {
-		log.Println("Cannot put object:", err)
+		return
 	}
+	var options PutOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.Size = int64(buf.Len())
+	options.Metadata = withCodec(options.Metadata)
+	// a reader can be rewound, so the write can be retried
+	_, err = s.putStream(ctx, objectName, bytes.NewReader(buf.Bytes()), options)
 	return
 }
 
+// add the codec metadata to user metadata
+func withCodec(metadata map[string]string) map[string]string {
+	withCodec := codecMetadata()
+	for k, v := range metadata {
+		if k != codecMeta {
+			withCodec[k] = v
+		}
+	}
+	return withCodec
+}
+
 // Get a specific piece of data for a given unique ID
 func (s *Store) GetObject(ctx context.Context, uid string) (obj any, err error) {
-	mObj, err := s.client.GetObject(ctx, s.bucket, uid, minio.GetObjectOptions{})
+	ctx, done := s.operation(ctx, "GetObject")
+	defer done(&err)
+	err = ValidateObjectName(uid)
+	if err != nil {
+		return
+	}
+	return s.getObject(ctx, uid)
+}
+
+func (s *Store) getObject(ctx context.Context, objectName string) (obj any, err error) {
+	mObj, err := s.openObject(ctx, objectName, minio.GetObjectOptions{})
 	if err != nil {
 		log.Println("Cannot get object:", err)
 		return
 	}
 	defer mObj.Close()
-	decoder := gob.NewDecoder(mObj)
-	err = decoder.Decode(&obj)
-	if err != nil {
-		log.Println("Cannot decode object:", err)
+	obj, stale, err := decodeObject(mObj)
+	err = inObject(err, objectName)
+	if err == nil && stale && s.migrationWriteBack {
+		// failing to write back doesn't stop the object from being read
+		s.rewriteObject(ctx, objectName, obj)
 	}
 	return
 }
 
+// write an object again, keeping its content type, metadata and tags
+func (s *Store) rewriteObject(ctx context.Context, objectName string, obj any) (err error) {
+	info, err := s.stat(ctx, objectName)
+	if err != nil {
+		return
+	}
+	return s.putObject(ctx, objectName, obj, PutOptions{
+		ContentType: info.ContentType,
+		Metadata:    info.Metadata,
+		Tags:        info.Tags,
+	})
+}
+
 // Delete a specific piece of data for a given unique ID
 func (s *Store) DeleteObject(ctx context.Context, uid string) (err error) {
-	err = s.client.RemoveObject(ctx, s.bucket, uid, minio.RemoveObjectOptions{})
+	ctx, done := s.operation(ctx, "DeleteObject")
+	defer done(&err)
+	err = ValidateObjectName(uid)
+	if err != nil {
+		return
+	}
+	return s.deleteObject(ctx, uid)
+}
+
+func (s *Store) deleteObject(ctx context.Context, objectName string) (err error) {
+	err = s.removeObject(ctx, objectName)
 	if err != nil {
 		log.Println("Cannot delete object:", err)
 	}
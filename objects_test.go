@@ -134,3 +134,40 @@ func TestObjectLeaderboard(t *testing.T) {
 		t.Errorf("Failed to delete: %v", err)
 	}
 }
+
+func TestPutObjectWithOptions(t *testing.T) {
+	setupObjects()
+	Register([]Thingy{})
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.PutObject(ctx, "tagged-things", objects, PutOptions{
+		ContentType: "application/x-gob",
+		Metadata:    map[string]string{"Owner": "sausheong"},
+		Tags:        map[string]string{"retention": "short"},
+	})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+
+	info, err := store.Stat(ctx, "tagged-things")
+	if err != nil {
+		t.Errorf("Failed to stat: %v", err)
+	}
+	if info.ContentType != "application/x-gob" {
+		t.Errorf("Failed to get the right content type: %v", info.ContentType)
+	}
+	if info.Metadata["Owner"] != "sausheong" {
+		t.Errorf("Failed to get the right metadata: %v", info.Metadata)
+	}
+	if info.Size <= 0 || info.LastModified.IsZero() || info.ETag == "" {
+		t.Errorf("Failed to get the object information: %v", info)
+	}
+
+	err = store.DeleteObject(ctx, "tagged-things")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+}
@@ -3,8 +3,13 @@ package gost
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/minio/minio-go/v7"
 )
@@ -22,30 +27,390 @@ var policyFormat = `{
 	]
 }`
 
+// user metadata used to track content-addressed published files
+const (
+	hashMeta = "Gost-Sha256"
+	refsMeta = "Gost-Refs"
+	// the blob a reference refers to
+	blobMeta = "Gost-Blob"
+)
+
+// get the name of the content-addressed blob for data with a given hash and
+// content type
+// Data published with different content types is stored in different blobs,
+// so each is served with the content type it was published with
+func blob(hash string, contentType string) string {
+	return "public/sha256/" + hash + "/" + encode(contentType)
+}
+
+// get the name of the blob a reference refers to from its metadata, which is
+// empty if it's not a reference
+// References made before blobs were kept for each content type refer to
+// public/sha256/<hash>
+func refBlob(metadata map[string]string) string {
+	if name := metadata[blobMeta]; name != "" {
+		return name
+	}
+	if hash := metadata[hashMeta]; hash != "" {
+		return "public/sha256/" + hash
+	}
+	return ""
+}
+
+// check if a published object can be a reference to a content-addressed
+// blob, rather than a blob itself
+func isReference(objectName string) bool {
+	return strings.HasPrefix(objectName, "public/") && !isBlob(objectName)
+}
+
+// check if a published object is a content-addressed blob
+func isBlob(objectName string) bool {
+	return strings.HasPrefix(objectName, "public/sha256/")
+}
+
+// get the location a reference redirects to when the bucket is served as a website
+func (s *Store) redirect(name string) string {
+	return "/" + s.bucket + "/" + name
+}
+
+// check that the filename can be published
+// Filenames follow the same rules as object names, except that they can
+// start with any prefix other than sha256/, which is used for content-addressed blobs
+func validateFilename(filename string) (err error) {
+	err = validatePath("filename", filename)
+	if err == nil && strings.HasPrefix(filename, "sha256/") {
+		err = &NameError{"filename", filename, "must not start with sha256/"}
+	}
+	return
+}
+
+// get the public URL of a published object
+func (s *Store) location(objectName string) string {
+	return s.client.EndpointURL().String() + "/" + s.bucket + "/" + objectName
+}
+
+// get the options for a published file, from the options given to Publish
+func publishOptions(contentType string, opts []PutOptions) (options PutOptions) {
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.ContentType = contentType
+	return
+}
+
 // Publish data and make it publicly available
-func (s *Store) Publish(ctx context.Context, filename string, contentType string, data []byte) (location string, err error) {
-	_, err = s.client.PutObject(ctx, s.bucket, "public/"+filename, bytes.NewReader(data), int64(len(data)),
-		minio.PutObjectOptions{ContentType: contentType})
-	location = s.client.EndpointURL().String() + "/" + s.bucket + "/public/" + filename
+// Options can be given to set the owner, user metadata and tags of the published file
+// Returns the SHA-256 hash of the data and the location of the published file.
+// If the store is content-addressed, the data is stored only once no matter
+// how many filenames it is published under, and the location of the shared
+// copy is returned
+func (s *Store) Publish(ctx context.Context, filename string, contentType string, data []byte, opts ...PutOptions) (hash string, location string, err error) {
+	ctx, done := s.operation(ctx, "Publish")
+	defer done(&err)
+	err = validateFilename(filename)
+	if err != nil {
+		return
+	}
+	if s.contentAddressed {
+		return s.publishContent(ctx, filename, contentType, data, opts...)
+	}
+	var previous string
+	if s.audit {
+		previous, err = s.publishedHash(ctx, filename)
+		if err != nil {
+			return
+		}
+	}
+	// a content-addressed reference being replaced no longer refers to its blob
+	ref, err := s.statObject(ctx, "public/"+filename)
+	if err != nil && !isNoSuchKey(err) {
+		log.Println("Cannot check published object:", err)
+		return
+	}
+	options := publishOptions(contentType, opts)
+	options.Size = int64(len(data))
+	_, err = s.putStream(ctx, "public/"+filename, bytes.NewReader(data), options)
+	location = s.location("public/" + filename)
 	if err != nil {
 		log.Println("Cannot publish object:", err)
+		return
+	}
+	if previousBlob := refBlob(ref.UserMetadata); previousBlob != "" {
+		err = s.release(ctx, previousBlob)
+		if err != nil {
+			return
+		}
+	}
+	sum := sha256.Sum256(data)
+	hash = hex.EncodeToString(sum[:])
+	s.recordPublished(ctx, "publish", filename, options.Owner, previous, hash)
+	return
+}
+
+// Publish data by content, storing it once under public/sha256/<hash>
+// The filename becomes a lightweight reference that redirects to the shared copy
+// Options can be given to set the owner, user metadata and tags of the reference
+// Returns the SHA-256 hash of the data and the location of the shared copy
+func (s *Store) PublishContent(ctx context.Context, filename string, contentType string, data []byte, opts ...PutOptions) (hash string, location string, err error) {
+	ctx, done := s.operation(ctx, "PublishContent")
+	defer done(&err)
+	err = validateFilename(filename)
+	if err != nil {
+		return
+	}
+	return s.publishContent(ctx, filename, contentType, data, opts...)
+}
+
+func (s *Store) publishContent(ctx context.Context, filename string, contentType string, data []byte, opts ...PutOptions) (hash string, location string, err error) {
+	sum := sha256.Sum256(data)
+	hash = hex.EncodeToString(sum[:])
+	name := blob(hash, contentType)
+	location = s.location(name)
+
+	// find out what the filename refers to at the moment
+	ref, err := s.statObject(ctx, "public/"+filename)
+	if err != nil && !isNoSuchKey(err) {
+		log.Println("Cannot check published object:", err)
+		return
+	}
+	previous, previousBlob := ref.UserMetadata[hashMeta], refBlob(ref.UserMetadata)
+
+	if previousBlob != name {
+		err = s.retain(ctx, name, hash, contentType, data)
+		if err != nil {
+			return
+		}
+	}
+	// the reference holds the hash, and redirects to the blob when served as a website
+	options := publishOptions("text/plain", opts)
+	options.Size = int64(len(hash))
+	metadata := make(map[string]string)
+	for k, v := range options.Metadata {
+		metadata[k] = v
+	}
+	metadata[hashMeta] = hash
+	metadata[blobMeta] = name
+	options.Metadata = metadata
+	_, putOptions := options.minio()
+	putOptions.WebsiteRedirectLocation = s.redirect(name)
+	err = s.indexOwner(ctx, "public/"+filename, putOptions.UserMetadata)
+	if err != nil {
+		return
+	}
+	_, err = s.upload(ctx, "public/"+filename, []byte(hash), putOptions)
+	if err != nil {
+		log.Println("Cannot publish reference:", err)
+		return
+	}
+	if previousBlob != "" && previousBlob != name {
+		err = s.release(ctx, previousBlob)
+		if err != nil {
+			return
+		}
+	}
+	s.recordPublished(ctx, "publish", filename, options.Owner, previous, hash)
+	return
+}
+
+// add a reference to a content-addressed blob, uploading it if it's not there yet
+func (s *Store) retain(ctx context.Context, name string, hash string, contentType string, data []byte) (err error) {
+	return s.updateRefs(ctx, name, func(info minio.ObjectInfo, found bool) error {
+		if !found {
+			// someone else uploading it at the same time makes this fail, and it's counted again
+			_, err := s.upload(ifNoneMatch(ctx), name, data,
+				minio.PutObjectOptions{
+					ContentType:  contentType,
+					UserMetadata: map[string]string{hashMeta: hash, refsMeta: "1"},
+				})
+			return err
+		}
+		refs, _ := strconv.Atoi(info.UserMetadata[refsMeta])
+		return s.setRefs(ctx, info, refs+1)
+	})
+}
+
+// remove a reference to a content-addressed blob, deleting it once nothing refers to it
+// The blob is only deleted if nothing has referred to it since it was read,
+// on object stores that support conditional deletes
+func (s *Store) release(ctx context.Context, name string) (err error) {
+	return s.updateRefs(ctx, name, func(info minio.ObjectInfo, found bool) error {
+		if !found {
+			return nil
+		}
+		refs, _ := strconv.Atoi(info.UserMetadata[refsMeta])
+		if refs > 1 {
+			return s.setRefs(ctx, info, refs-1)
+		}
+		return s.removeObject(ifMatch(ctx, info.ETag), name)
+	})
+}
+
+// read a content-addressed blob and change its reference count with fn
+// The count is only changed if the blob hasn't changed since it was read,
+// otherwise it's read again and fn is called again, so references added and
+// removed at the same time aren't lost
+func (s *Store) updateRefs(ctx context.Context, name string, fn func(info minio.ObjectInfo, found bool) error) (err error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err = sleep(ctx, backoff(attempt))
+			if err != nil {
+				return
+			}
+		}
+		info, err := s.statObject(ctx, name)
+		if err != nil && !isNoSuchKey(err) {
+			log.Println("Cannot check published blob:", err)
+			return err
+		}
+		err = fn(info, err == nil)
+		if !isPreconditionFailed(err) {
+			if err != nil {
+				log.Println("Cannot update published blob:", err)
+			}
+			return err
+		}
+	}
+	log.Println("Cannot update published blob:", ErrConflict)
+	return ErrConflict
+}
+
+// changes to the reference counts of content-addressed blobs, made when
+// references are copied in from somewhere else, like an archive or another store
+type refChanges map[string]int
+
+// count a reference to one blob being replaced with a reference to another,
+// where either can be empty if it's not a reference
+func (c refChanges) replace(old string, new string) {
+	if old == new {
+		return
+	}
+	if new != "" {
+		c[new]++
+	}
+	if old != "" {
+		c[old]--
+	}
+}
+
+// change the reference counts of content-addressed blobs, deleting the ones
+// nothing refers to any more
+// Blobs that aren't in the store, like ones that weren't copied along with
+// their references, are left out
+func (s *Store) applyRefs(ctx context.Context, changes refChanges) (err error) {
+	for name, n := range changes {
+		if n == 0 {
+			continue
+		}
+		err = s.updateRefs(ctx, name, func(info minio.ObjectInfo, found bool) error {
+			if !found {
+				return nil
+			}
+			refs, _ := strconv.Atoi(info.UserMetadata[refsMeta])
+			if refs+n > 0 {
+				return s.setRefs(ctx, info, refs+n)
+			}
+			return s.removeObject(ifMatch(ctx, info.ETag), name)
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// put a content-addressed blob copied from somewhere else, unless the store
+// already has it
+// Its reference count isn't copied, as it doesn't count the references that
+// are already in the store, so it starts at zero and the references are
+// counted with applyRefs as they're copied
+func (s *Store) putBlob(ctx context.Context, objectName string, r io.Reader, opts PutOptions) (put bool, err error) {
+	metadata := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[refsMeta] = "0"
+	opts.Metadata = metadata
+	_, err = s.putStream(ifNoneMatch(ctx), objectName, r, opts)
+	if isPreconditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// set the reference count of a content-addressed blob in place, as long as it
+// hasn't changed since it was read
+// Like conditional writes in upload, the copy isn't retried, and is tagged
+// with an ID so a copy the minio client sent again is recognised
+func (s *Store) setRefs(ctx context.Context, info minio.ObjectInfo, refs int) (err error) {
+	writeID := newWriteID()
+	err = s.callWith(ctx, "copy object", RetryPolicy{MaxAttempts: 1}, func() (err error) {
+		_, err = s.client.CopyObject(ctx,
+			minio.CopyDestOptions{
+				Bucket:          s.bucket,
+				Object:          info.Key,
+				ReplaceMetadata: true,
+				UserMetadata: map[string]string{
+					"Content-Type": info.ContentType,
+					hashMeta:       info.UserMetadata[hashMeta],
+					refsMeta:       strconv.Itoa(refs),
+					writeIDMeta:    writeID,
+				},
+			},
+			minio.CopySrcOptions{Bucket: s.bucket, Object: info.Key, MatchETag: info.ETag})
+		return
+	})
+	if isPreconditionFailed(err) {
+		_, err = s.written(ctx, info.Key, writeID, err)
 	}
 	return
 }
 
 // Delete published data
+// If the filename refers to a content-addressed blob, the blob is deleted
+// when no other filename refers to it
 func (s *Store) Unpublish(ctx context.Context, filename string) (err error) {
-	err = s.client.RemoveObject(ctx, s.bucket, "public/"+filename, minio.RemoveObjectOptions{})
+	ctx, done := s.operation(ctx, "Unpublish")
+	defer done(&err)
+	err = validateFilename(filename)
+	if err != nil {
+		return
+	}
+	var previous string
+	if s.audit {
+		previous, err = s.publishedHash(ctx, filename)
+		if err != nil {
+			return
+		}
+	}
+	ref, err := s.statObject(ctx, "public/"+filename)
+	if err != nil && !isNoSuchKey(err) {
+		log.Println("Cannot check published object:", err)
+		return
+	}
+	err = s.removeObject(ctx, "public/"+filename)
 	if err != nil {
 		log.Println("Cannot unpublish object:", err)
+		return
 	}
+	if name := refBlob(ref.UserMetadata); name != "" {
+		err = s.release(ctx, name)
+		if err != nil {
+			return
+		}
+	}
+	owner, _ := decode(ref.UserMetadata[ownerMeta])
+	s.recordPublished(ctx, "unpublish", filename, owner, previous, "")
 	return
 }
 
 // Programmatically set up bucket folder /public to be publicly readable
 func (s *Store) AllowPublic(ctx context.Context) (err error) {
+	ctx, done := s.operation(ctx, "AllowPublic")
+	defer done(&err)
 	policy := fmt.Sprintf(policyFormat, "Allow", s.bucket, "public")
-	err = s.client.SetBucketPolicy(ctx, s.bucket, policy)
+	err = s.call(ctx, "set bucket policy", func() error {
+		return s.client.SetBucketPolicy(ctx, s.bucket, policy)
+	})
 	if err != nil {
 		log.Println("Cannot set bucket policy:", err)
 	}
@@ -54,8 +419,12 @@ func (s *Store) AllowPublic(ctx context.Context) (err error) {
 
 // Programmatically set up bucket folder /public to be private
 func (s *Store) DenyPublic(ctx context.Context) (err error) {
+	ctx, done := s.operation(ctx, "DenyPublic")
+	defer done(&err)
 	policy := fmt.Sprintf(policyFormat, "Deny", s.bucket, "public")
-	err = s.client.SetBucketPolicy(ctx, s.bucket, policy)
+	err = s.call(ctx, "set bucket policy", func() error {
+		return s.client.SetBucketPolicy(ctx, s.bucket, policy)
+	})
 	if err != nil {
 		log.Println("Cannot set bucket policy:", err)
 	}
@@ -63,7 +432,13 @@ func (s *Store) DenyPublic(ctx context.Context) (err error) {
 }
 
 func (s *Store) IsPublic(ctx context.Context) (isPublic bool, err error) {
-	policy, err := s.client.GetBucketPolicy(ctx, s.bucket)
+	ctx, done := s.operation(ctx, "IsPublic")
+	defer done(&err)
+	var policy string
+	err = s.call(ctx, "get bucket policy", func() (err error) {
+		policy, err = s.client.GetBucketPolicy(ctx, s.bucket)
+		return
+	})
 	if err != nil {
 		log.Println("Cannot get bucket policy:", err)
 		return
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"testing"
+
+	"github.com/minio/minio-go/v7"
 )
 
 func TestPublish(t *testing.T) {
@@ -17,7 +19,7 @@ func TestPublish(t *testing.T) {
 	if err != nil {
 		t.Errorf("Failed to read test.png: %v", err)
 	}
-	loc, err := store.Publish(context.Background(), "test.png", "image/png", imageBytes)
+	_, loc, err := store.Publish(context.Background(), "test.png", "image/png", imageBytes)
 	if err != nil {
 		t.Errorf("Failed to publish: %v", err)
 	}
@@ -74,3 +76,91 @@ func TestCheckPublic(t *testing.T) {
 
 	fmt.Println("public: ", isPublic)
 }
+
+func TestPublishContent(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithContentAddressedPublish())
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	imageBytes, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Errorf("Failed to read test.png: %v", err)
+	}
+	ctx := context.Background()
+	hash, loc, err := store.PublishContent(ctx, "avatar-1.png", "image/png", imageBytes)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	hash2, loc2, err := store.Publish(ctx, "avatar-2.png", "image/png", imageBytes)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	if hash != hash2 || loc != loc2 {
+		t.Errorf("Same content should be published to the same location: %v, %v", loc, loc2)
+	}
+
+	// the blob should survive until the last reference is unpublished
+	err = store.Unpublish(ctx, "avatar-1.png")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = store.client.StatObject(ctx, store.bucket, blob(hash, "image/png"), minio.StatObjectOptions{})
+	if err != nil {
+		t.Errorf("Blob should still exist: %v", err)
+	}
+	err = store.Unpublish(ctx, "avatar-2.png")
+	if err != nil {
+		t.Errorf("Failed to unpublish: %v", err)
+	}
+	_, err = store.client.StatObject(ctx, store.bucket, blob(hash, "image/png"), minio.StatObjectOptions{})
+	if !isNoSuchKey(err) {
+		t.Errorf("Blob should have been removed: %v", err)
+	}
+}
+
+func TestPublishContentTypes(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithContentAddressedPublish())
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	plain, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	data := []byte("the same content")
+	hash, loc, err := store.PublishContent(ctx, "types-1.txt", "text/plain", data)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	hash2, loc2, err := store.PublishContent(ctx, "types-2.csv", "text/csv", data)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	// the same content with another content type is the same hash in another blob
+	if hash != hash2 || loc == loc2 {
+		t.Errorf("Content types should have their own blobs: %v, %v", loc, loc2)
+	}
+	info, err := store.statObject(ctx, blob(hash, "text/csv"))
+	if err != nil || info.ContentType != "text/csv" {
+		t.Errorf("Blob should have its content type: %v, %v", info.ContentType, err)
+	}
+
+	// publishing over a reference without content addressing releases its blob
+	_, _, err = plain.Publish(ctx, "types-2.csv", "text/csv", data)
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+	_, err = store.statObject(ctx, blob(hash, "text/csv"))
+	if !isNoSuchKey(err) {
+		t.Errorf("Blob should have been removed: %v", err)
+	}
+	for _, filename := range []string{"types-1.txt", "types-2.csv"} {
+		err = store.Unpublish(ctx, filename)
+		if err != nil {
+			t.Errorf("Failed to unpublish: %v", err)
+		}
+	}
+}
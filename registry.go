@@ -0,0 +1,311 @@
+package gost
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnregisteredType is matched by errors.Is for every UnregisteredTypeError
+var ErrUnregisteredType = errors.New("unregistered type")
+
+// UnregisteredTypeError is returned when stored data holds values of types
+// that aren't registered
+type UnregisteredTypeError struct {
+	Object string   // the object the types were found in, if there's only one
+	Types  []string // the names of the types, sorted
+}
+
+func (e *UnregisteredTypeError) Error() string {
+	if e.Object == "" {
+		return fmt.Sprintf("gost: types not registered: %s", strings.Join(e.Types, ", "))
+	}
+	return fmt.Sprintf("gost: types not registered in %s: %s", e.Object, strings.Join(e.Types, ", "))
+}
+
+func (e *UnregisteredTypeError) Is(target error) bool {
+	return target == ErrUnregisteredType
+}
+
+// add the type names to the error, returning a new error if it's nil
+func (e *UnregisteredTypeError) add(types ...string) *UnregisteredTypeError {
+	if e == nil {
+		e = &UnregisteredTypeError{}
+	}
+	for _, t := range types {
+		i := sort.SearchStrings(e.Types, t)
+		if i == len(e.Types) || e.Types[i] != t {
+			e.Types = append(e.Types[:i], append([]string{t}, e.Types[i:]...)...)
+		}
+	}
+	return e
+}
+
+// set the object an UnregisteredTypeError was found in
+func inObject(err error, objectName string) error {
+	var unregistered *UnregisteredTypeError
+	if errors.As(err, &unregistered) && unregistered.Object == "" {
+		unregistered.Object = objectName
+	}
+	return err
+}
+
+// the error gob returns when it decodes an interface holding an unregistered type
+const gobUnregistered = "gob: name not registered for interface: "
+
+// turn the error gob returns for an unregistered type into an UnregisteredTypeError
+func gobError(err error) error {
+	if err == nil || !strings.HasPrefix(err.Error(), gobUnregistered) {
+		return err
+	}
+	name, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), gobUnregistered))
+	if unquoteErr != nil {
+		return err
+	}
+	return (*UnregisteredTypeError)(nil).add(name)
+}
+
+// registered types, and the names and schema versions they are stored with
+var registry = struct {
+	sync.RWMutex
+	// the name each type was registered with
+	names map[reflect.Type]string
+	// migrations from each type to the next version of it
+	migrations map[reflect.Type]migration
+	// the previous version of each type that has one
+	previous map[reflect.Type]reflect.Type
+	// other names types were stored with
+	aliases map[string]reflect.Type
+
+	// worked out from the above by index
+	types  map[reflect.Type]typeVersion
+	byName map[string]map[int]reflect.Type
+}{
+	names:      make(map[reflect.Type]string),
+	migrations: make(map[reflect.Type]migration),
+	previous:   make(map[reflect.Type]reflect.Type),
+	aliases:    make(map[string]reflect.Type),
+}
+
+// a migration of a value to the next version of its type
+type migration struct {
+	to reflect.Type
+	fn func(any) (any, error)
+}
+
+// the name and schema version a type is stored with
+type typeVersion struct {
+	name    string
+	version int
+}
+
+// get the name gob uses for a type that is registered without a name
+func defaultName(rt reflect.Type) string {
+	if rt.Name() != "" && rt.PkgPath() != "" {
+		return rt.PkgPath() + "." + rt.Name()
+	}
+	return rt.String()
+}
+
+// Register a struct to be stored in the database
+// The struct is stored with the name gob gives it, which includes the
+// package path, so use RegisterName instead if the struct might move
+func Register(data any) {
+	registerGob(data)
+	registry.Lock()
+	defer registry.Unlock()
+	rt := reflect.TypeOf(data)
+	if _, ok := registry.names[rt]; !ok {
+		registry.names[rt] = defaultName(rt)
+		index()
+	}
+}
+
+// RegisterName registers a struct to be stored in the database with the given
+// name, which stays the same when the struct moves to another package
+// If the struct was already registered with another name, values stored with
+// that name can still be read
+func RegisterName(name string, data any) {
+	if name == "" {
+		panic("gost: registering a type with an empty name")
+	}
+	registerGob(data)
+	registry.Lock()
+	defer registry.Unlock()
+	rt := reflect.TypeOf(data)
+	checkName(name, rt)
+	if previous, ok := registry.names[rt]; ok && previous != name {
+		registry.aliases[previous] = rt
+	}
+	registry.names[rt] = name
+	index()
+}
+
+// RegisterAlias registers another name values of a registered struct were
+// stored with, like the name it was stored with before it was renamed
+func RegisterAlias(alias string, data any) {
+	registry.Lock()
+	defer registry.Unlock()
+	rt := reflect.TypeOf(data)
+	if _, ok := registry.names[rt]; !ok {
+		panic(fmt.Sprintf("gost: registering an alias for %v, which is not registered", rt))
+	}
+	checkName(alias, rt)
+	registry.aliases[alias] = rt
+	index()
+}
+
+// panic if the name is already used for another type
+// Must be called with the registry locked
+func checkName(name string, rt reflect.Type) {
+	for t, n := range registry.names {
+		if n == name && t != rt {
+			panic(fmt.Sprintf("gost: registering %v with the name %q of %v", rt, name, t))
+		}
+	}
+	if t, ok := registry.aliases[name]; ok && t != rt {
+		panic(fmt.Sprintf("gost: registering %v with the alias %q of %v", rt, name, t))
+	}
+}
+
+// the panic gob.Register makes when the type is already registered by another name
+const gobDuplicateName = "gob: registering duplicate names for "
+
+// register a type with gob, so values stored before envelopes can be decoded
+// Types gob already knows by another name are left as they are, but gob's
+// other panics, like for another type already registered with the name, are
+// passed on
+func registerGob(data any) {
+	defer func() {
+		if r := recover(); r != nil {
+			if msg, ok := r.(string); !ok || !strings.HasPrefix(msg, gobDuplicateName) {
+				panic(r)
+			}
+		}
+	}()
+	gob.Register(data)
+}
+
+// Migrate registers a function that upgrades stored values of type Old to
+// type New, the next version of the same type
+// Old values are stored under the name of the newest version of the type
+// with their schema version, starting from 1 for the oldest version, so
+// both types can keep the name the data was originally stored with. Values
+// are upgraded when they are read, through as many migrations as it takes
+// to get to the newest version
+func Migrate[Old any, New any](fn func(Old) (New, error)) {
+	var old Old
+	var new New
+	from, to := reflect.TypeOf(&old).Elem(), reflect.TypeOf(&new).Elem()
+	// values stored before envelopes are decoded by gob, which needs to know the old type
+	if from.Kind() != reflect.Interface {
+		registerGob(old)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if m, ok := registry.migrations[from]; ok && m.to != to {
+		panic(fmt.Sprintf("gost: %v already migrates to %v", from, m.to))
+	}
+	if p, ok := registry.previous[to]; ok && p != from {
+		panic(fmt.Sprintf("gost: %v already migrates from %v", to, p))
+	}
+	for t := to; t != nil; t = registry.migrations[t].to {
+		if t == from {
+			panic(fmt.Sprintf("gost: migrating %v to %v makes a cycle", from, to))
+		}
+	}
+	for _, t := range []reflect.Type{from, to} {
+		if _, ok := registry.names[t]; !ok {
+			registry.names[t] = defaultName(t)
+		}
+	}
+	registry.migrations[from] = migration{to, func(v any) (any, error) {
+		return fn(v.(Old))
+	}}
+	registry.previous[to] = from
+	index()
+}
+
+// work out the name and version every registered type is stored with
+// Must be called with the registry locked
+func index() {
+	registry.types = make(map[reflect.Type]typeVersion)
+	registry.byName = make(map[string]map[int]reflect.Type)
+	for rt := range registry.names {
+		newest := rt
+		for {
+			m, ok := registry.migrations[newest]
+			if !ok {
+				break
+			}
+			newest = m.to
+		}
+		version := 1
+		for t := registry.previous[rt]; t != nil; t = registry.previous[t] {
+			version++
+		}
+		tv := typeVersion{registry.names[newest], version}
+		registry.types[rt] = tv
+		if registry.byName[tv.name] == nil {
+			registry.byName[tv.name] = make(map[int]reflect.Type)
+		}
+		registry.byName[tv.name][version] = rt
+	}
+	for alias, rt := range registry.aliases {
+		if tv, ok := registry.types[rt]; ok && alias != tv.name {
+			registry.byName[alias] = registry.byName[tv.name]
+		}
+	}
+}
+
+// get the name and version a type is stored with, if it's registered
+func lookupType(rt reflect.Type) (tv typeVersion, ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	tv, ok = registry.types[rt]
+	return
+}
+
+// get the type stored with a name and version, if it's registered
+func lookupName(name string, version int) (rt reflect.Type, ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	rt, ok = registry.byName[name][version]
+	return
+}
+
+// describe a type name and version that isn't registered
+// The version is only given if other versions of the type are registered
+func unregisteredName(name string, version int) string {
+	registry.RLock()
+	defer registry.RUnlock()
+	if _, ok := registry.byName[name]; ok {
+		return fmt.Sprintf("%s version %d", name, version)
+	}
+	return name
+}
+
+// upgrade a value to the newest version of its type
+func migrate(v any) (upgraded any, migrated bool, err error) {
+	upgraded = v
+	for upgraded != nil {
+		registry.RLock()
+		m, ok := registry.migrations[reflect.TypeOf(upgraded)]
+		registry.RUnlock()
+		if !ok {
+			return
+		}
+		upgraded, err = m.fn(upgraded)
+		if err != nil {
+			return
+		}
+		migrated = true
+	}
+	return
+}
@@ -0,0 +1,252 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// RetryPolicy says how calls to the object store that fail with transient
+// errors are retried
+type RetryPolicy struct {
+	// The most times a call is made, 1 turns retrying off
+	MaxAttempts int
+	// The wait before the first retry, doubling with each retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// How much of each wait is random, from 0 to 1, so clients that failed
+	// together don't retry together
+	Jitter float64
+	// Decides if an error is worth retrying, IsTransient if it's nil
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is the retry policy of stores not created with WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Jitter:         0.5,
+}
+
+// WithRetryPolicy sets how the store retries calls to the object store that
+// fail with transient errors
+// The minio client under the store also retries some failed requests by
+// itself before gost sees them
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *Store) {
+		s.retry = policy
+	}
+}
+
+// check if the policy says the error is worth retrying
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// how long to wait before a retry, attempt is 0 for the first retry
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	d := p.InitialBackoff << attempt
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+	return d
+}
+
+// the error codes the object store uses when it's overloaded or having trouble
+var transientCodes = map[string]bool{
+	"SlowDown":             true,
+	"RequestTimeout":       true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestLimitExceeded": true,
+	"RequestThrottled":     true,
+	"InternalError":        true,
+	"ServiceUnavailable":   true,
+}
+
+// IsTransient reports whether an error from the object store is likely to go
+// away if the call is made again, like throttling with SlowDown, 5xx errors,
+// timeouts and dropped connections
+// Errors from the context being done aren't transient
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	resp := minio.ToErrorResponse(err)
+	if transientCodes[resp.Code] || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true
+	}
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// ErrCircuitOpen is returned straight away, without calling the object
+// store, while the circuit breaker is open
+var ErrCircuitOpen = errors.New("gost: circuit breaker is open")
+
+// BreakerPolicy says when the circuit breaker opens and for how long
+type BreakerPolicy struct {
+	// The number of calls in a row failing with transient errors that opens the breaker
+	Failures int
+	// How long the breaker stays open before it lets a call through to check
+	// if the object store is back
+	OpenFor time.Duration
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// calls go through
+	BreakerClosed BreakerState = iota
+	// calls fail straight away with ErrCircuitOpen
+	BreakerOpen
+	// one call goes through to check if the object store is back, the others fail
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// BreakerStats describes what the circuit breaker of a store has been doing
+type BreakerStats struct {
+	State BreakerState
+	// the number of calls in a row that failed with transient errors
+	Failures int
+	// the number of times the breaker opened, and when it last did
+	Opened   int
+	OpenedAt time.Time
+	// the number of calls failed with ErrCircuitOpen
+	Rejected int
+}
+
+// WithCircuitBreaker makes the store stop calling the object store for a
+// while after a number of calls in a row fail with transient errors, failing
+// calls straight away with ErrCircuitOpen instead, so an outage isn't made
+// worse by callers waiting on retries
+func WithCircuitBreaker(policy BreakerPolicy) Option {
+	return func(s *Store) {
+		s.breaker = &breaker{policy: policy}
+	}
+}
+
+// a circuit breaker
+type breaker struct {
+	policy BreakerPolicy
+	mutex  sync.Mutex
+	stats  BreakerStats
+	// a call is checking if the object store is back
+	probing bool
+}
+
+// check if a call can go through
+func (b *breaker) allow() (err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.stats.State {
+	case BreakerOpen:
+		if time.Since(b.stats.OpenedAt) < b.policy.OpenFor {
+			b.stats.Rejected++
+			return ErrCircuitOpen
+		}
+		b.stats.State = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			b.stats.Rejected++
+			return ErrCircuitOpen
+		}
+	}
+	b.probing = b.stats.State == BreakerHalfOpen
+	return
+}
+
+// count how a call that went through went, unless it was stopped by its
+// context, which says nothing about the object store
+func (b *breaker) done(transient bool, stopped bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if stopped {
+		return
+	}
+	if !transient {
+		b.stats.State, b.stats.Failures = BreakerClosed, 0
+		return
+	}
+	b.stats.Failures++
+	if b.stats.State == BreakerHalfOpen || b.stats.Failures >= b.policy.Failures {
+		if b.stats.State != BreakerOpen {
+			b.stats.Opened++
+		}
+		b.stats.State, b.stats.OpenedAt = BreakerOpen, time.Now()
+	}
+}
+
+// BreakerStats returns what the circuit breaker of the store has been doing,
+// which is all zero if the store doesn't have one
+func (s *Store) BreakerStats() BreakerStats {
+	if s.breaker == nil {
+		return BreakerStats{}
+	}
+	s.breaker.mutex.Lock()
+	defer s.breaker.mutex.Unlock()
+	return s.breaker.stats
+}
+
+// make a call to the object store, going through the circuit breaker and
+// retrying it with the store's retry policy if it fails with a transient error
+func (s *Store) call(ctx context.Context, op string, fn func() error) (err error) {
+	return s.callWith(ctx, op, s.retry, fn)
+}
+
+// make a call to the object store, retrying it with the given policy
+func (s *Store) callWith(ctx context.Context, op string, policy RetryPolicy, fn func() error) (err error) {
+	for attempt := 1; ; attempt++ {
+		if s.breaker != nil {
+			if err = s.breaker.allow(); err != nil {
+				return
+			}
+		}
+		err = fn()
+		retryable := err != nil && policy.retryable(err)
+		if s.breaker != nil {
+			s.breaker.done(retryable, ctx.Err() != nil)
+		}
+		if !retryable || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return
+		}
+		observed(ctx).retried(ctx, op, attempt, err)
+		if sleepErr := sleep(ctx, policy.wait(attempt-1)); sleepErr != nil {
+			return
+		}
+	}
+}
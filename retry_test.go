@@ -0,0 +1,231 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestIsTransient(t *testing.T) {
+	transient := []error{
+		minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable},
+		minio.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError},
+		minio.ErrorResponse{StatusCode: http.StatusTooManyRequests},
+		fmt.Errorf("reading: %w", io.ErrUnexpectedEOF),
+	}
+	for _, err := range transient {
+		if !IsTransient(err) {
+			t.Errorf("Error should be transient: %v", err)
+		}
+	}
+	permanent := []error{
+		nil,
+		minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound},
+		minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden},
+		minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: http.StatusPreconditionFailed},
+		context.Canceled,
+		context.DeadlineExceeded,
+		errors.New("something else"),
+	}
+	for _, err := range permanent {
+		if IsTransient(err) {
+			t.Errorf("Error should not be transient: %v", err)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	slowDown := minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}
+	calls := 0
+	err = store.call(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return slowDown
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Call should succeed on the third attempt: %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = store.call(context.Background(), "test", func() error {
+		calls++
+		return slowDown
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Call should fail after 3 attempts: %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = store.call(context.Background(), "test", func() error {
+		calls++
+		return minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Call should not be retried: %v after %d calls", err, calls)
+	}
+	// the store still works with the policy
+	err = store.Put(context.Background(), "retry-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	a, err := store.Get(context.Background(), "retry-test", "a")
+	if err != nil || a != "first" {
+		t.Errorf("Failed to get: %v, got %v", err, a)
+	}
+}
+
+// failingTransport fails the first write it sends with a transient error
+type failingTransport struct {
+	http.RoundTripper
+	writes int
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	t.writes++
+	if t.writes > 1 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Status:     "503 Service Unavailable",
+		Header:     http.Header{"Content-Type": {"application/xml"}},
+		Body:       io.NopCloser(strings.NewReader("<Error><Code>SlowDown</Code><Message>Slow down</Message></Error>")),
+		Request:    req,
+	}, nil
+}
+
+func TestPutObjectRetried(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	transport := &failingTransport{RoundTripper: http.DefaultTransport}
+	store.client, err = minio.New(endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(key, secret, ""),
+		Secure:    useSSL,
+		Region:    "us-east-1",
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	// the minio client retries on its own, so stop it so the store does
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	defer func() { minio.MaxRetry = maxRetry }()
+
+	err = store.PutObject(context.Background(), "retried-object", "an object")
+	if err != nil || transport.writes != 2 {
+		t.Errorf("Write should be retried: %v after %d writes", err, transport.writes)
+	}
+	obj, err := store.GetObject(context.Background(), "retried-object")
+	if err != nil || obj != "an object" {
+		t.Errorf("Failed to get: %v, got %v", err, obj)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithCircuitBreaker(BreakerPolicy{Failures: 2, OpenFor: 50 * time.Millisecond}))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	slowDown := minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}
+	calls := 0
+	failing := func() error {
+		calls++
+		return slowDown
+	}
+	for i := 0; i < 2; i++ {
+		store.call(context.Background(), "test", failing)
+	}
+	if stats := store.BreakerStats(); stats.State != BreakerOpen || stats.Opened != 1 {
+		t.Errorf("Breaker should be open: %+v", stats)
+	}
+	err = store.call(context.Background(), "test", failing)
+	if !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Errorf("Call should fail straight away: %v after %d calls", err, calls)
+	}
+	if stats := store.BreakerStats(); stats.Rejected != 1 {
+		t.Errorf("Breaker should have rejected a call: %+v", stats)
+	}
+	// after a while a call goes through, and the breaker closes when it succeeds
+	time.Sleep(60 * time.Millisecond)
+	err = store.call(context.Background(), "test", func() error { return nil })
+	if err != nil {
+		t.Errorf("Call should go through: %v", err)
+	}
+	if stats := store.BreakerStats(); stats.State != BreakerClosed || stats.Failures != 0 {
+		t.Errorf("Breaker should be closed: %+v", stats)
+	}
+	// a failed check opens it again straight away
+	for i := 0; i < 2; i++ {
+		store.call(context.Background(), "test", failing)
+	}
+	time.Sleep(60 * time.Millisecond)
+	store.call(context.Background(), "test", failing)
+	if stats := store.BreakerStats(); stats.State != BreakerOpen || stats.Opened != 3 {
+		t.Errorf("Breaker should be open again: %+v", stats)
+	}
+}
+
+func TestConditionalWriteSentAgain(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	objectName := name("write-test")
+	store.removeObject(ctx, objectName)
+	// the object isn't gob encoded data, so it mustn't be left for other tests to read
+	defer store.removeObject(ctx, objectName)
+	_, err = store.upload(ifNoneMatch(ctx), objectName, []byte("first"), minio.PutObjectOptions{})
+	if err != nil {
+		t.Errorf("Failed to write: %v", err)
+	}
+	// a write that's sent once and fails its condition fails
+	_, err = store.upload(ifNoneMatch(ctx), objectName, []byte("second"), minio.PutObjectOptions{})
+	if !isPreconditionFailed(err) {
+		t.Errorf("Write should fail its condition: %v", err)
+	}
+	stat, err := store.statObject(ctx, objectName)
+	if err != nil {
+		t.Errorf("Failed to stat: %v", err)
+	}
+	writeID := stat.UserMetadata[writeIDMeta]
+	if writeID == "" {
+		t.Fatalf("Object should have a write ID: %v", stat.UserMetadata)
+	}
+	// a write sent again fails its condition, but succeeded if the object has its ID
+	failed := minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: http.StatusPreconditionFailed}
+	info, err := store.written(ctx, objectName, writeID, failed)
+	if err != nil || info.ETag != stat.ETag {
+		t.Errorf("Write should have succeeded: %v, %+v", err, info)
+	}
+	_, err = store.written(ctx, objectName, newWriteID(), failed)
+	if !isPreconditionFailed(err) {
+		t.Errorf("Write should have failed: %v", err)
+	}
+}
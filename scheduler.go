@@ -0,0 +1,273 @@
+package gost
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// how long the lock for a running job is held before it has to be renewed
+const jobLockTTL = time.Minute
+
+// get the name of the lock for a scheduled job
+func jobLock(name string) string {
+	return "jobs/" + name
+}
+
+// get the name of the object holding the status of a scheduled job
+func jobObject(name string) string {
+	return manifestsPrefix + "jobs/" + encode(name) + ".json"
+}
+
+// BackupJob is a backup run on a schedule by a Scheduler
+type BackupJob struct {
+	// Names the job, its lock and its status
+	Name string
+	// When the job runs, like "30 2 * * *" or "@every 6h", see ParseSchedule
+	Schedule string
+	// The unique IDs backed up with Backup. If it's empty, every unique ID is
+	// backed up with BackupAll
+	UIDs []string
+	// The options for BackupAll. The Store is where the backups go, for
+	// BackupAll or Backup, and where the status of the job is kept
+	Options BulkOptions
+	// The backup generations kept after each run, all of them if it's zero
+	Retention Retention
+}
+
+// JobStatus is how the last run of a scheduled job went
+// It's kept as JSON under gost/manifests/jobs/, so every replica running the job
+// sees the same status
+type JobStatus struct {
+	Name string
+	// Running stays set if the replica running the job stopped before it finished
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	// the error if the run failed
+	Error string `json:",omitempty"`
+	// the manifest written by BackupAll
+	Manifest string `json:",omitempty"`
+	// the unique IDs that failed to back up
+	Failures []ManifestFailure `json:",omitempty"`
+	// the number of backup generations removed by the retention
+	Pruned int
+	// when the job runs next
+	Next time.Time `json:"-"`
+}
+
+// Scheduler runs backup jobs on a schedule inside a service
+// Every replica of the service can run a scheduler with the same jobs. Each
+// job takes a lock while it runs, so it only runs once for each time it's due
+type Scheduler struct {
+	store *Store
+	jobs  []*scheduledJob
+}
+
+// a job added to a scheduler
+type scheduledJob struct {
+	BackupJob
+	schedule Schedule
+}
+
+// NewScheduler creates a scheduler for jobs backing up the store
+func NewScheduler(store *Store) *Scheduler {
+	return &Scheduler{store: store}
+}
+
+// Add adds a job to the scheduler
+// Jobs must be added before the scheduler is run
+func (sc *Scheduler) Add(job BackupJob) (err error) {
+	err = validateText("job", job.Name, MaxKeyLength)
+	if err != nil {
+		return
+	}
+	if sc.job(job.Name) != nil {
+		return fmt.Errorf("gost: job %q has already been added", job.Name)
+	}
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return
+	}
+	sc.jobs = append(sc.jobs, &scheduledJob{job, schedule})
+	return
+}
+
+// find a job by its name
+func (sc *Scheduler) job(name string) *scheduledJob {
+	for _, job := range sc.jobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// Run runs each job every time it's due, until the context is done, and
+// then returns the context's error
+// A job is skipped if it's already running, here or in another replica
+func (sc *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, job := range sc.jobs {
+		wg.Add(1)
+		go func(job *scheduledJob) {
+			defer wg.Done()
+			for {
+				due := job.schedule.Next(time.Now())
+				if due.IsZero() || sleep(ctx, time.Until(due)) != nil {
+					return
+				}
+				runCtx, done := sc.store.longOperation(ctx, "Scheduler.Run")
+				_, err := sc.run(runCtx, job, due)
+				done(&err)
+				if err != nil && err != ErrLocked && ctx.Err() == nil {
+					log.Println("Cannot run scheduled job:", err)
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// RunNow runs a job straight away, and returns how it went
+// It returns ErrLocked if the job is already running
+func (sc *Scheduler) RunNow(ctx context.Context, name string) (status JobStatus, err error) {
+	ctx, done := sc.store.longOperation(ctx, "Scheduler.RunNow")
+	defer done(&err)
+	job := sc.job(name)
+	if job == nil {
+		err = fmt.Errorf("gost: no job %q", name)
+		return
+	}
+	return sc.run(ctx, job, time.Time{})
+}
+
+// Status returns how the last run of each job went, in the order they were added
+func (sc *Scheduler) Status(ctx context.Context) (statuses []JobStatus, err error) {
+	ctx, done := sc.store.longOperation(ctx, "Scheduler.Status")
+	defer done(&err)
+	for _, job := range sc.jobs {
+		var status JobStatus
+		_, err = sc.store.bulkStore(job.Options).readJSON(ctx, jobObject(job.Name), &status)
+		if err != nil {
+			return
+		}
+		status.Name = job.Name
+		status.Next = job.schedule.Next(time.Now())
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+// run a job while holding its lock, unless it has already run since it was
+// due, and save how it went
+func (sc *Scheduler) run(ctx context.Context, job *scheduledJob, due time.Time) (status JobStatus, err error) {
+	target := sc.store.bulkStore(job.Options)
+	lease, err := sc.store.TryLock(ctx, jobLock(job.Name), jobLockTTL)
+	if err != nil {
+		return
+	}
+	defer func() {
+		// release the lock even if the context has been cancelled
+		releaseCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if releaseErr := lease.Release(releaseCtx); releaseErr != nil {
+			log.Println("Cannot release job lock:", releaseErr)
+		}
+	}()
+	_, err = target.readJSON(ctx, jobObject(job.Name), &status)
+	if err != nil {
+		return
+	}
+	if !due.IsZero() && !status.Started.Before(due) {
+		// another replica ran the job after it was due
+		status.Next = job.schedule.Next(time.Now())
+		return
+	}
+	status = JobStatus{Name: job.Name, Running: true, Started: time.Now().UTC()}
+	err = target.writeJSON(ctx, jobObject(job.Name), status)
+	if err != nil {
+		return
+	}
+
+	// keep the lock while the job runs, and stop the job if the lock is lost
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(jobLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				if renewErr := lease.Renew(jobCtx); renewErr != nil && jobCtx.Err() == nil {
+					log.Println("Cannot renew job lock:", renewErr)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err = sc.backup(jobCtx, job, target, &status)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.Running, status.Finished = false, time.Now().UTC()
+	saveCtx, cancelSave := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelSave()
+	if saveErr := target.writeJSON(saveCtx, jobObject(job.Name), status); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	status.Next = job.schedule.Next(time.Now())
+	return
+}
+
+// back up the unique IDs of a job into the target store, and prune their
+// old backup generations
+func (sc *Scheduler) backup(ctx context.Context, job *scheduledJob, target *Store, status *JobStatus) (err error) {
+	uids := job.UIDs
+	if len(uids) == 0 {
+		var manifest BackupManifest
+		manifest, err = sc.store.BackupAll(ctx, job.Options)
+		status.Manifest, status.Failures = manifest.ID, manifest.Failures
+		if err != nil {
+			return
+		}
+	} else {
+		for _, uid := range uids {
+			_, _, backupErr := sc.store.backupTo(ctx, uid, target)
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			if backupErr != nil {
+				status.Failures = append(status.Failures, ManifestFailure{uid, backupErr.Error()})
+			}
+		}
+	}
+	if job.Retention == (Retention{}) {
+		return
+	}
+	if len(job.UIDs) == 0 {
+		uids, err = target.backupUIDs(ctx)
+		if err != nil {
+			return
+		}
+	}
+	// carry on pruning the other unique IDs if one fails, and report the first failure
+	var pruneErr error
+	for _, uid := range uids {
+		removed, uidErr := target.prune(ctx, uid, job.Retention)
+		status.Pruned += removed
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if uidErr != nil && pruneErr == nil {
+			pruneErr = fmt.Errorf("gost: cannot prune the backups of %q: %w", uid, uidErr)
+		}
+	}
+	return pruneErr
+}
@@ -0,0 +1,136 @@
+package gost
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either the day of the month or the day of the week
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"@every 10s", time.Date(2024, 1, 31, 10, 17, 40, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		sched, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.spec, err)
+			continue
+		}
+		if next := sched.Next(from); !next.Equal(test.next) {
+			t.Errorf("Failed to get the next time for %q: %v, should be %v", test.spec, next, test.next)
+		}
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every -1h"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Should fail to parse %q", spec)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	for i := 0; i < fullBackupEvery+3; i++ {
+		err = store.Put(ctx, "prune-test", "count", i)
+		if err != nil {
+			t.Errorf("Failed to store: %v", err)
+		}
+		err = store.Backup(ctx, "prune-test")
+		if err != nil {
+			t.Errorf("Failed to backup: %v", err)
+		}
+	}
+	// keeping the last 2 generations needs the diffs back to the full generation before them
+	removed, err := store.Prune(ctx, "prune-test", Retention{Generations: 2})
+	if err != nil {
+		t.Errorf("Failed to prune: %v", err)
+	}
+	if removed != fullBackupEvery {
+		t.Errorf("Failed to remove the right number of generations: %d", removed)
+	}
+	gens, err := store.Generations(ctx, "prune-test")
+	if err != nil {
+		t.Errorf("Failed to list generations: %v", err)
+	}
+	if len(gens) != 3 || !gens[0].Full {
+		t.Errorf("Failed to keep the right generations: %+v", gens)
+	}
+	data, err := store.Load(ctx, "prune-test")
+	if err != nil {
+		t.Errorf("Failed to load: %v", err)
+	}
+	if data["count"] != fullBackupEvery+2 {
+		t.Errorf("Failed to load the latest backup: %v", data)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "scheduler-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	scheduler := NewScheduler(store)
+	err = scheduler.Add(BackupJob{Name: "nightly", Schedule: "0 2 * * *", UIDs: []string{"scheduler-test"}, Retention: Retention{Generations: 1}})
+	if err != nil {
+		t.Errorf("Failed to add job: %v", err)
+	}
+	err = scheduler.Add(BackupJob{Name: "broken", Schedule: "every day"})
+	if err == nil {
+		t.Errorf("Should fail to add a job with a bad schedule")
+	}
+
+	status, err := scheduler.RunNow(ctx, "nightly")
+	if err != nil {
+		t.Errorf("Failed to run job: %v", err)
+	}
+	if status.Running || status.Finished.IsZero() || status.Error != "" || len(status.Failures) != 0 {
+		t.Errorf("Failed to get the right status: %+v", status)
+	}
+	gens, err := store.Generations(ctx, "scheduler-test")
+	if err != nil || len(gens) == 0 {
+		t.Errorf("Failed to back up: %v", err)
+	}
+
+	// the job shouldn't run while someone else holds its lock
+	lease, err := store.TryLock(ctx, jobLock("nightly"), time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	_, err = scheduler.RunNow(ctx, "nightly")
+	if err != ErrLocked {
+		t.Errorf("Should not run a locked job: %v", err)
+	}
+	lease.Release(ctx)
+
+	statuses, err := scheduler.Status(ctx)
+	if err != nil {
+		t.Errorf("Failed to get status: %v", err)
+	}
+	if len(statuses) != 1 || !statuses[0].Started.Equal(status.Started) || statuses[0].Next.Hour() != 2 {
+		t.Errorf("Failed to get the right status: %+v", statuses)
+	}
+}
@@ -2,52 +2,107 @@ package gost
 
 import (
 	"context"
-	"encoding/gob"
 	"log"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Store is the main struct for the database
 type Store struct {
-	client *minio.Client
-	bucket string
+	client             *minio.Client
+	bucket             string
+	contentAddressed   bool
+	migrationWriteBack bool
+	audit              bool
+	history            bool
+	backupStore        *Store
+	timeout            time.Duration
+	retry              RetryPolicy
+	breaker            *breaker
+	tracerProvider     trace.TracerProvider
+	meterProvider      metric.MeterProvider
+	telemetry          telemetry
 }
 
-// Create a new store
-func NewStore(key string, secret string, endpoint string, useSSL bool, region string, bucket string) (s *Store, err error) {
+// Option configures optional behaviour of a store
+type Option func(*Store)
+
+// WithContentAddressedPublish makes Publish store each file once under
+// public/sha256/<hash>, with the published filename as a reference to it
+func WithContentAddressedPublish() Option {
+	return func(s *Store) {
+		s.contentAddressed = true
+	}
+}
+
+// WithMigrationWriteBack makes the store write data back when it's read, if
+// it's stored in an older format or has values that were upgraded by
+// migrations, so the next read doesn't need to upgrade it again
+func WithMigrationWriteBack() Option {
+	return func(s *Store) {
+		s.migrationWriteBack = true
+	}
+}
+
+// Create a new store, making the bucket if it doesn't exist
+//
+// Deprecated: use NewStoreContext, which can give up when the context is done
+func NewStore(key string, secret string, endpoint string, useSSL bool, region string, bucket string, opts ...Option) (s *Store, err error) {
+	return NewStoreContext(context.Background(), key, secret, endpoint, useSSL, region, bucket, opts...)
+}
+
+// NewStoreContext creates a new store, making the bucket if it doesn't exist,
+// and returns the error if it can't, like when the context is done
+func NewStoreContext(ctx context.Context, key string, secret string, endpoint string, useSSL bool, region string, bucket string, opts ...Option) (s *Store, err error) {
 	s = &Store{
 		bucket: bucket,
+		retry:  DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.telemetry = newTelemetry(s.tracerProvider, s.meterProvider)
 	if region == "" {
 		region = "us-east-1"
 	}
-	ctx := context.Background()
+	transport, err := minio.DefaultTransport(useSSL)
+	if err != nil {
+		log.Println("Cannot create transport:", err)
+		return nil, err
+	}
 	s.client, err = minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(key, secret, ""),
-		Secure: useSSL,
-		Region: region,
+		Creds:     credentials.NewStaticV4(key, secret, ""),
+		Secure:    useSSL,
+		Region:    region,
+		Transport: conditionalTransport{transport},
 	})
 	if err != nil {
-		log.Fatalln("Cannot initiate client:", err)
+		log.Println("Cannot initiate client:", err)
+		return nil, err
 	}
 
-	exists, err := s.client.BucketExists(ctx, bucket)
+	var exists bool
+	err = s.call(ctx, "check bucket", func() (err error) {
+		exists, err = s.client.BucketExists(ctx, bucket)
+		return
+	})
 	if err != nil {
-		log.Fatalln("Cannot check if bucket exists:", err)
+		log.Println("Cannot check if bucket exists:", err)
+		return nil, err
 	}
 	if !exists {
 		// Make gost bucket
-		err = s.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region})
+		err = s.call(ctx, "make bucket", func() error {
+			return s.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region})
+		})
 		if err != nil {
-			log.Fatalln("Gost bucket doesn't exist but we can't make the bucket either:", err)
+			log.Println("Gost bucket doesn't exist but we can't make the bucket either:", err)
+			return nil, err
 		}
 	}
 	return
 }
-
-// Register a struct to be stored in the database
-func Register(data any) {
-	gob.Register(data)
-}
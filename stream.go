@@ -0,0 +1,236 @@
+package gost
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// ObjectInfo describes an object in the store
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+	Metadata     map[string]string
+	Tags         map[string]string
+	// Unique ID of the user the object belongs to, if any
+	Owner string
+}
+
+// user metadata with the (encoded) unique ID of the user an object belongs to
+const ownerMeta = "Gost-Owner"
+
+// PutOptions are the options for putting raw objects into the store
+type PutOptions struct {
+	// Size of the data, if it is known. Zero or less means the size is
+	// unknown and the data is uploaded in parts until the reader is exhausted
+	Size int64
+	// Defaults to application/octet-stream
+	ContentType string
+	// User metadata, stored with the object and returned by Stat
+	Metadata map[string]string
+	// Tags, which can be used in lifecycle rules and policies on the bucket
+	Tags map[string]string
+	// Unique ID of the user the object belongs to, which ExportUser and
+	// PurgeUser use to find the objects belonging to a user
+	Owner string
+}
+
+// convert the object information from the object store
+func objectInfo(info minio.ObjectInfo) (converted ObjectInfo) {
+	converted = ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		Metadata:     info.UserMetadata,
+	}
+	if owner, ok := info.UserMetadata[ownerMeta]; ok {
+		converted.Owner, _ = decode(owner)
+	}
+	return
+}
+
+// convert the put options to the object store's options
+func (opts PutOptions) minio() (size int64, options minio.PutObjectOptions) {
+	size = opts.Size
+	if size <= 0 {
+		size = -1
+	}
+	options.ContentType = opts.ContentType
+	if options.ContentType == "" {
+		options.ContentType = "application/octet-stream"
+	}
+	options.UserMetadata = opts.Metadata
+	if opts.Owner != "" {
+		options.UserMetadata = make(map[string]string)
+		for k, v := range opts.Metadata {
+			options.UserMetadata[k] = v
+		}
+		options.UserMetadata[ownerMeta] = encode(opts.Owner)
+	}
+	options.UserTags = opts.Tags
+	return
+}
+
+// Put raw data from a reader into the database, with an associated unique ID
+// The data is streamed to the object store without being buffered in memory
+func (s *Store) PutStream(ctx context.Context, uid string, r io.Reader, opts PutOptions) (info ObjectInfo, err error) {
+	ctx, done := s.longOperation(ctx, "PutStream")
+	defer done(&err)
+	err = ValidateObjectName(uid)
+	if err != nil {
+		return
+	}
+	return s.putStream(ctx, uid, r, opts)
+}
+
+func (s *Store) putStream(ctx context.Context, objectName string, r io.Reader, opts PutOptions) (info ObjectInfo, err error) {
+	size, options := opts.minio()
+	// references to content-addressed blobs redirect to them, wherever they
+	// are written from
+	if blob := refBlob(options.UserMetadata); blob != "" && isReference(objectName) {
+		options.WebsiteRedirectLocation = s.redirect(blob)
+	}
+	// the stream can only be sent again if it can be rewound, and conditional
+	// writes aren't sent again, like in upload
+	policy := RetryPolicy{MaxAttempts: 1}
+	_, conditional := conditionOf(ctx)
+	seeker, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		seekable = err == nil
+		if seekable && !conditional {
+			policy = s.retry
+		}
+	}
+	err = s.indexOwner(ctx, objectName, options.UserMetadata)
+	if err != nil {
+		return
+	}
+	var upload minio.UploadInfo
+	err = s.callWith(ctx, "put object", policy, func() (err error) {
+		if seekable {
+			if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+				return
+			}
+		}
+		upload, err = s.client.PutObject(ctx, s.bucket, objectName, r, size, options)
+		return
+	})
+	if err != nil {
+		if !isPreconditionFailed(err) {
+			log.Println("Cannot put object:", err)
+		}
+		return
+	}
+	s.transferred(ctx, "write", upload.Size)
+	info = ObjectInfo{
+		Key:          upload.Key,
+		Size:         upload.Size,
+		LastModified: upload.LastModified,
+		ETag:         upload.ETag,
+		ContentType:  options.ContentType,
+		Metadata:     options.UserMetadata,
+		Tags:         opts.Tags,
+		Owner:        opts.Owner,
+	}
+	return
+}
+
+// Get information about the object with the given unique ID, without getting the object itself
+func (s *Store) Stat(ctx context.Context, uid string) (info ObjectInfo, err error) {
+	ctx, done := s.operation(ctx, "Stat")
+	defer done(&err)
+	err = ValidateObjectName(uid)
+	if err != nil {
+		return
+	}
+	return s.stat(ctx, uid)
+}
+
+func (s *Store) stat(ctx context.Context, objectName string) (info ObjectInfo, err error) {
+	stat, err := s.statObject(ctx, objectName)
+	if err != nil {
+		log.Println("Cannot stat object:", err)
+		return
+	}
+	info = objectInfo(stat)
+	if stat.UserTagCount > 0 {
+		var objectTags *tags.Tags
+		err = s.call(ctx, "get object tags", func() (err error) {
+			objectTags, err = s.client.GetObjectTagging(ctx, s.bucket, objectName, minio.GetObjectTaggingOptions{})
+			return
+		})
+		if err != nil {
+			log.Println("Cannot get object tags:", err)
+			return info, err
+		}
+		info.Tags = objectTags.ToMap()
+	}
+	return
+}
+
+// Get raw data for a given unique ID as a reader
+// The caller must close the reader when done
+func (s *Store) GetStream(ctx context.Context, uid string) (r io.ReadCloser, info ObjectInfo, err error) {
+	ctx, done := s.longOperation(ctx, "GetStream")
+	defer done(&err)
+	return s.GetRange(ctx, uid, 0, 0)
+}
+
+// Get part of the raw data for a given unique ID as a reader, starting at
+// offset and reading length bytes. If length is zero or less the data is
+// read until the end. The returned info describes the whole object
+// The caller must close the reader when done
+func (s *Store) GetRange(ctx context.Context, uid string, offset int64, length int64) (r io.ReadCloser, info ObjectInfo, err error) {
+	ctx, done := s.longOperation(ctx, "GetRange")
+	defer done(&err)
+	err = ValidateObjectName(uid)
+	if err != nil {
+		return
+	}
+	return s.getRange(ctx, uid, offset, length)
+}
+
+func (s *Store) getRange(ctx context.Context, objectName string, offset int64, length int64) (r io.ReadCloser, info ObjectInfo, err error) {
+	// stat separately, calling Stat on the object itself drops the range
+	stat, err := s.statObject(ctx, objectName)
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	options := minio.GetObjectOptions{}
+	// make sure the data read is from the same object that was stat'ed
+	err = options.SetMatchETag(stat.ETag)
+	if err != nil {
+		log.Println("Cannot set etag:", err)
+		return
+	}
+	if offset > 0 || length > 0 {
+		end := int64(0)
+		if length > 0 {
+			end = offset + length - 1
+		}
+		err = options.SetRange(offset, end)
+		if err != nil {
+			log.Println("Cannot set range:", err)
+			return
+		}
+	}
+	obj, err := s.openObject(ctx, objectName, options)
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	r, info = obj, objectInfo(stat)
+	return
+}
@@ -0,0 +1,102 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestPutStream(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	file, err := os.Open("test.png")
+	if err != nil {
+		t.Errorf("Failed to open test.png: %v", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		t.Errorf("Failed to stat test.png: %v", err)
+	}
+	info, err := store.PutStream(context.Background(), "test-stream.png", file,
+		PutOptions{Size: stat.Size(), ContentType: "image/png"})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	if info.Size != stat.Size() {
+		t.Errorf("Failed to get the right size: %v", info.Size)
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	imageBytes, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Errorf("Failed to read test.png: %v", err)
+	}
+	r, info, err := store.GetStream(context.Background(), "test-stream.png")
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Errorf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(data, imageBytes) {
+		t.Errorf("Failed to get the right data")
+	}
+	if info.ContentType != "image/png" {
+		t.Errorf("Failed to get the right content type: %v", info.ContentType)
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	imageBytes, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Errorf("Failed to read test.png: %v", err)
+	}
+	r, _, err := store.GetRange(context.Background(), "test-stream.png", 1, 3)
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Errorf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(data, imageBytes[1:4]) {
+		t.Errorf("Failed to get the right range: %q", data)
+	}
+
+	err = store.DeleteObject(context.Background(), "test-stream.png")
+	if err != nil {
+		t.Errorf("Failed to delete: %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	_, err = store.Stat(context.Background(), "does-not-exist")
+	if !isNoSuchKey(err) {
+		t.Errorf("Stat of a missing object should fail with NoSuchKey: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the name the tracer and meter of a store are created with
+const instrumentationName = "github.com/sausheong/gost"
+
+// the attributes of spans and metrics
+const (
+	operationKey = attribute.Key("gost.operation")
+	bucketKey    = attribute.Key("gost.bucket")
+	sizeKey      = attribute.Key("gost.size")
+	codecKey     = attribute.Key("gost.codec")
+	retriesKey   = attribute.Key("gost.retries")
+	errorCodeKey = attribute.Key("gost.error_code")
+	directionKey = attribute.Key("gost.direction")
+	callKey      = attribute.Key("gost.call")
+	attemptKey   = attribute.Key("gost.attempt")
+)
+
+// WithTracerProvider sets the tracer provider the store sends a span to for
+// every operation, instead of the global one from otel.GetTracerProvider
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(s *Store) {
+		s.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider sets the meter provider the store records its metrics
+// with, instead of the global one from otel.GetMeterProvider
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(s *Store) {
+		s.meterProvider = provider
+	}
+}
+
+// the tracer and metrics of a store
+type telemetry struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+	bytes    metric.Int64Counter
+}
+
+// create the tracer and metrics of a store, from the global providers if the
+// store wasn't given any, which do nothing until they are set
+func newTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (t telemetry) {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	t.tracer = tracerProvider.Tracer(instrumentationName)
+	meter := meterProvider.Meter(instrumentationName)
+	var err error
+	t.duration, err = meter.Float64Histogram("gost.operation.duration",
+		metric.WithUnit("s"), metric.WithDescription("How long gost operations take"))
+	if err != nil {
+		log.Println("Cannot create duration histogram:", err)
+		t.duration = noop.Float64Histogram{}
+	}
+	t.errors, err = meter.Int64Counter("gost.operation.errors",
+		metric.WithDescription("The number of gost operations that failed, by error code"))
+	if err != nil {
+		log.Println("Cannot create error counter:", err)
+		t.errors = noop.Int64Counter{}
+	}
+	t.bytes, err = meter.Int64Counter("gost.transferred",
+		metric.WithUnit("By"), metric.WithDescription("The number of bytes read from and written to the object store"))
+	if err != nil {
+		log.Println("Cannot create bytes counter:", err)
+		t.bytes = noop.Int64Counter{}
+	}
+	return
+}
+
+// what happened during an operation, kept in its context
+type observation struct {
+	name string
+	// the operation this one is part of, like GetAll is part of Get
+	parent *observation
+	// counted with atomics, because operations on many unique IDs make calls concurrently
+	size    int64
+	retries int64
+	codec   int64
+}
+
+type observationKey struct{}
+
+// get the operation a context is for, which is nil if it's not for one
+func observed(ctx context.Context) *observation {
+	o, _ := ctx.Value(observationKey{}).(*observation)
+	return o
+}
+
+// start a span for an operation
+// The returned function ends the span and records the operation's metrics.
+// An operation started by another one, like GetAll by Get, gets a span of its
+// own, but what happened in it is added to the other operation, which is the
+// only one counted in the metrics
+func (s *Store) observe(ctx context.Context, name string) (context.Context, func(error)) {
+	o := &observation{name: name, parent: observed(ctx)}
+	attrs := []attribute.KeyValue{operationKey.String(name), bucketKey.String(s.bucket)}
+	start := time.Now()
+	ctx, span := s.telemetry.tracer.Start(context.WithValue(ctx, observationKey{}, o), "gost."+name,
+		trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		span.SetAttributes(sizeKey.Int64(atomic.LoadInt64(&o.size)), retriesKey.Int64(atomic.LoadInt64(&o.retries)))
+		if codec := atomic.LoadInt64(&o.codec); codec > 0 {
+			span.SetAttributes(codecKey.Int64(codec))
+		}
+		if err != nil {
+			span.SetAttributes(errorCodeKey.String(errorCode(err)))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if o.parent != nil {
+			o.parent.add(o)
+			return
+		}
+		if err != nil {
+			s.telemetry.errors.Add(ctx, 1, metric.WithAttributes(append(attrs, errorCodeKey.String(errorCode(err)))...))
+		}
+		s.telemetry.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	}
+}
+
+// add what happened in an operation to the one it's part of
+func (o *observation) add(child *observation) {
+	atomic.AddInt64(&o.size, atomic.LoadInt64(&child.size))
+	atomic.AddInt64(&o.retries, atomic.LoadInt64(&child.retries))
+	if codec := atomic.LoadInt64(&child.codec); codec > 0 {
+		atomic.StoreInt64(&o.codec, codec)
+	}
+}
+
+// count bytes read from or written to the object store
+// Bytes read from a stream after its operation has returned are counted in
+// the metrics, but not the operation's span
+func (s *Store) transferred(ctx context.Context, direction string, n int64) {
+	if n <= 0 {
+		return
+	}
+	attrs := []attribute.KeyValue{bucketKey.String(s.bucket), directionKey.String(direction)}
+	if o := observed(ctx); o != nil {
+		atomic.AddInt64(&o.size, n)
+		// bytes are counted against the operation the caller started
+		top := o
+		for top.parent != nil {
+			top = top.parent
+		}
+		attrs = append(attrs, operationKey.String(top.name))
+	}
+	s.telemetry.bytes.Add(ctx, n, metric.WithAttributes(attrs...))
+}
+
+// note the codec version of data read or written
+func (o *observation) sawCodec(metadata map[string]string) {
+	if o == nil {
+		return
+	}
+	if v, ok := metadata[codecMeta]; ok {
+		if version, err := strconv.Atoi(v); err == nil {
+			atomic.StoreInt64(&o.codec, int64(version))
+		}
+	}
+}
+
+// note a call being retried, on the operation's span
+// Retries are expected, so they aren't logged, but they show up in traces
+func (o *observation) retried(ctx context.Context, op string, attempt int, err error) {
+	if o != nil {
+		atomic.AddInt64(&o.retries, 1)
+	}
+	trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+		callKey.String(op), attemptKey.Int(attempt), errorCodeKey.String(errorCode(err))))
+}
+
+// the codes of gost's own errors
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{context.Canceled, "Canceled"},
+	{context.DeadlineExceeded, "DeadlineExceeded"},
+	{ErrCircuitOpen, "CircuitOpen"},
+	{ErrLocked, "Locked"},
+	{ErrLeaseLost, "LeaseLost"},
+	{ErrConflict, "Conflict"},
+	{ErrInvalidName, "InvalidName"},
+	{ErrUnregisteredType, "UnregisteredType"},
+	{ErrWrongType, "WrongType"},
+	{ErrCorruptBackup, "CorruptBackup"},
+	{ErrNoSuchGeneration, "NoSuchGeneration"},
+	{ErrNoSuchVersion, "NoSuchVersion"},
+	{ErrNoManifest, "NoManifest"},
+}
+
+// get the code of an error for metrics, which is the object store's error
+// code, like NoSuchKey or SlowDown, for errors from the object store
+func errorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && resp.Code != "" {
+		return resp.Code
+	}
+	return "Other"
+}
@@ -0,0 +1,153 @@
+package gost
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// find the ended spans with a name
+func spansNamed(recorder *tracetest.SpanRecorder, name string) (spans []sdktrace.ReadOnlySpan) {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return
+}
+
+// get an attribute of a span
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (value attribute.Value, ok bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return
+}
+
+func TestTelemetry(t *testing.T) {
+	setup()
+	recorder := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "telemetry-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	_, err = store.Get(ctx, "telemetry-test", "")
+	if err == nil {
+		t.Errorf("Get with an empty key should fail")
+	}
+
+	puts := spansNamed(recorder, "gost.Put")
+	if len(puts) != 1 {
+		t.Fatalf("There should be a span for Put: %d", len(puts))
+	}
+	if v, _ := spanAttribute(puts[0], operationKey); v.AsString() != "Put" {
+		t.Errorf("Span should have the operation: %v", v.AsString())
+	}
+	if v, _ := spanAttribute(puts[0], bucketKey); v.AsString() != bucket {
+		t.Errorf("Span should have the bucket: %v", v.AsString())
+	}
+	if v, _ := spanAttribute(puts[0], codecKey); v.AsInt64() != codecVersion {
+		t.Errorf("Span should have the codec version: %v", v.AsInt64())
+	}
+	if v, _ := spanAttribute(puts[0], sizeKey); v.AsInt64() <= 0 {
+		t.Errorf("Span should have the size: %v", v.AsInt64())
+	}
+	gets := spansNamed(recorder, "gost.Get")
+	if len(gets) != 1 || gets[0].Status().Code != codes.Error {
+		t.Fatalf("There should be a failed span for Get: %d", len(gets))
+	}
+	if v, _ := spanAttribute(gets[0], errorCodeKey); v.AsString() != "InvalidName" {
+		t.Errorf("Span should have the error code: %v", v.AsString())
+	}
+
+	// retries are counted on the operation's span
+	opCtx, done := store.operation(ctx, "Test")
+	callErr := store.call(opCtx, "test", func() error {
+		return minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}
+	})
+	done(&callErr)
+	tests := spansNamed(recorder, "gost.Test")
+	if len(tests) != 1 {
+		t.Fatalf("There should be a span for the test operation: %d", len(tests))
+	}
+	if v, _ := spanAttribute(tests[0], retriesKey); v.AsInt64() != 1 {
+		t.Errorf("Span should have the retry count: %v", v.AsInt64())
+	}
+	if len(tests[0].Events()) == 0 || tests[0].Events()[0].Name != "retry" {
+		t.Errorf("Span should have a retry event: %v", tests[0].Events())
+	}
+
+	// operations started by other operations are added to them
+	_, err = store.Get(ctx, "telemetry-test", "a")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	gets = spansNamed(recorder, "gost.Get")
+	if len(gets) != 2 || len(spansNamed(recorder, "gost.GetAll")) != 1 {
+		t.Fatalf("There should be spans for Get and the GetAll it started: %d", len(gets))
+	}
+	if v, _ := spanAttribute(gets[1], sizeKey); v.AsInt64() <= 0 {
+		t.Errorf("Span should have the size read by GetAll: %v", v.AsInt64())
+	}
+
+	var rm metricdata.ResourceMetrics
+	err = reader.Collect(ctx, &rm)
+	if err != nil {
+		t.Errorf("Failed to collect metrics: %v", err)
+	}
+	metrics := make(map[string]metricdata.Metrics)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	duration, ok := metrics["gost.operation.duration"].Data.(metricdata.Histogram[float64])
+	if !ok || len(duration.DataPoints) == 0 {
+		t.Errorf("There should be a duration histogram: %v", metrics["gost.operation.duration"])
+	}
+	for _, dp := range duration.DataPoints {
+		if op, _ := dp.Attributes.Value(operationKey); op.AsString() == "GetAll" {
+			t.Errorf("GetAll started by Get should not be counted on its own: %v", dp)
+		}
+	}
+	errorCounts, ok := metrics["gost.operation.errors"].Data.(metricdata.Sum[int64])
+	found := false
+	for _, dp := range errorCounts.DataPoints {
+		code, _ := dp.Attributes.Value(errorCodeKey)
+		op, _ := dp.Attributes.Value(operationKey)
+		found = found || (code.AsString() == "InvalidName" && op.AsString() == "Get" && dp.Value == 1)
+	}
+	if !ok || !found {
+		t.Errorf("There should be an error count for Get: %v", errorCounts)
+	}
+	transferred, ok := metrics["gost.transferred"].Data.(metricdata.Sum[int64])
+	written := int64(0)
+	for _, dp := range transferred.DataPoints {
+		if direction, _ := dp.Attributes.Value(directionKey); direction.AsString() == "write" {
+			written += dp.Value
+		}
+	}
+	if !ok || written <= 0 {
+		t.Errorf("There should be a count of bytes written: %v", transferred)
+	}
+}
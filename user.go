@@ -0,0 +1,578 @@
+package gost
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ExportFormat is the archive format used by ExportUser
+type ExportFormat int
+
+const (
+	ExportZip ExportFormat = iota
+	ExportTar
+)
+
+// UserManifest lists everything exported for a user by ExportUser
+// It's written into the archive as manifest.json
+type UserManifest struct {
+	UID      string
+	Exported time.Time
+	Files    []ManifestFile
+}
+
+// ManifestFile describes a file in an archive written by ExportUser
+type ManifestFile struct {
+	// Name of the file in the archive
+	Name string
+	// Name of the object in the store the file was exported from
+	Object string
+	// What the file is: data, backup, history, audit, object or published
+	Kind         string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	SHA256       string
+}
+
+// the prefix of the index of the objects owned by each unique ID
+const ownersPrefix = gostPrefix + "owners/"
+
+// get the prefix of the index entries of the objects owned by a unique ID
+func ownerObjects(uid string) string {
+	return ownersPrefix + encode(uid) + "/"
+}
+
+// add an object to the index of the objects owned by the unique ID in its
+// metadata, if it has one, so ExportUser and PurgeUser find it without
+// listing the whole bucket
+// The entry is written before the object, so an object is never missing
+// from the index. Entries left behind by objects that were removed or given
+// another owner are removed when the objects of the unique ID are listed
+func (s *Store) indexOwner(ctx context.Context, objectName string, metadata map[string]string) (err error) {
+	owner, ok := metadata[ownerMeta]
+	if !ok {
+		return
+	}
+	// the entry holds the name of the object, because some object stores
+	// turn away empty objects
+	_, err = s.upload(unconditional(ctx), ownersPrefix+owner+"/"+objectName, []byte(objectName),
+		minio.PutObjectOptions{ContentType: "text/plain"})
+	if err != nil {
+		log.Println("Cannot index owner:", err)
+	}
+	return
+}
+
+// IndexOwners adds every raw object and published file with an owner to the
+// index ExportUser and PurgeUser use to find them
+// Objects are indexed when they are put, so this only needs to be called
+// once, for objects put by versions of gost that didn't index them
+func (s *Store) IndexOwners(ctx context.Context) (err error) {
+	ctx, done := s.longOperation(ctx, "IndexOwners")
+	defer done(&err)
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		if isBlob(obj.Key) ||
+			(isReserved(obj.Key) && !strings.HasPrefix(obj.Key, "public/") && !strings.HasPrefix(obj.Key, collectionPrefix)) {
+			continue
+		}
+		var info minio.ObjectInfo
+		info, err = s.statObject(ctx, obj.Key)
+		if isNoSuchKey(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot stat object:", err)
+			return
+		}
+		err = s.indexOwner(ctx, obj.Key, info.UserMetadata)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// an object belonging to a user
+type userObject struct {
+	kind string
+	info ObjectInfo
+	// the store the object is in, which is the backup store for backups
+	store *Store
+}
+
+// find all the objects belonging to a user
+// That's the data, backups, history and audit records for the unique ID, and
+// the raw objects and published files owned by the unique ID
+func (s *Store) userObjects(ctx context.Context, uid string) (objects []userObject, err error) {
+	add := func(store *Store, kind string, objectName string) (err error) {
+		info, err := store.stat(ctx, objectName)
+		if isNoSuchKey(err) {
+			return nil
+		}
+		if err == nil {
+			objects = append(objects, userObject{kind, info, store})
+		}
+		return
+	}
+	for _, objectName := range unique(name(uid), legacyName(uid)) {
+		if err = add(s, "data", objectName); err != nil {
+			return
+		}
+	}
+	gens, err := s.Generations(ctx, uid)
+	if err != nil {
+		return
+	}
+	for _, gen := range gens {
+		if err = add(s.backups(), "backup", gen.Object); err != nil {
+			return
+		}
+	}
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: historyPrefix + encode(uid) + "/", Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		if err = add(s, "history", obj.Key); err != nil {
+			return
+		}
+	}
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: auditObjects(uid), Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		if err = add(s, "audit", obj.Key); err != nil {
+			return
+		}
+	}
+	prefix := ownerObjects(uid)
+	for entry := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if entry.Err != nil {
+			err = entry.Err
+			log.Println("Cannot list objects:", err)
+			return
+		}
+		objectName := strings.TrimPrefix(entry.Key, prefix)
+		var info ObjectInfo
+		info, err = s.stat(ctx, objectName)
+		if err != nil && !isNoSuchKey(err) {
+			return
+		}
+		if err != nil || info.Owner != uid {
+			// the object was removed or given to someone else
+			err = s.removeObject(ctx, entry.Key)
+			if err != nil {
+				log.Println("Cannot remove owner index entry:", err)
+				return
+			}
+			continue
+		}
+		kind := "object"
+		if strings.HasPrefix(objectName, "public/") {
+			kind = "published"
+		}
+		objects = append(objects, userObject{kind, info, s})
+	}
+	return
+}
+
+// remove duplicate names, for unique IDs whose legacy names are the same as their current names
+func unique(names ...string) (result []string) {
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	return
+}
+
+// writes files into an archive
+type archiveWriter interface {
+	create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type zipArchive struct{ *zip.Writer }
+
+func (a zipArchive) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	return a.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+}
+
+type tarArchive struct{ *tar.Writer }
+
+func (a tarArchive) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := a.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: modTime})
+	return a, err
+}
+
+// ExportUser writes everything held about a user into an archive, for
+// example to answer a subject access request
+// The archive has the data and backup for the unique ID, both as they are
+// stored and as JSON where the data can be converted, the history of its
+// keys, the audit records of changes to its data, the raw objects and
+// published files owned by the unique ID (see PutOptions.Owner), and a
+// manifest.json describing all of them
+func (s *Store) ExportUser(ctx context.Context, uid string, w io.Writer, format ExportFormat) (manifest UserManifest, err error) {
+	ctx, done := s.longOperation(ctx, "ExportUser")
+	defer done(&err)
+	err = ValidateUID(uid)
+	if err != nil {
+		return
+	}
+	var archive archiveWriter
+	switch format {
+	case ExportZip:
+		archive = zipArchive{zip.NewWriter(w)}
+	case ExportTar:
+		archive = tarArchive{tar.NewWriter(w)}
+	default:
+		err = fmt.Errorf("unknown export format %d", format)
+		return
+	}
+	objects, err := s.userObjects(ctx, uid)
+	if err != nil {
+		return
+	}
+	manifest = UserManifest{UID: uid, Exported: time.Now().UTC()}
+	for _, obj := range objects {
+		var files []ManifestFile
+		files, err = s.exportUserObject(ctx, archive, obj)
+		if err != nil {
+			return
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	fw, err := archive.create("manifest.json", int64(len(body)), manifest.Exported)
+	if err == nil {
+		_, err = fw.Write(body)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+	}
+	return
+}
+
+// write an object belonging to a user into the archive, together with its JSON version if it has one
+func (s *Store) exportUserObject(ctx context.Context, archive archiveWriter, obj userObject) (files []ManifestFile, err error) {
+	info := obj.info
+	source := info.Key
+	// content-addressed published files are exported with the content they refer to
+	if name := refBlob(info.Metadata); obj.kind == "published" && name != "" {
+		info, err = s.stat(ctx, name)
+		if err != nil {
+			return
+		}
+	}
+	r, err := obj.store.openObject(ctx, info.Key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get object:", err)
+		return
+	}
+	defer r.Close()
+	var name string
+	switch obj.kind {
+	case "data", "backup":
+		name = obj.kind + "/" + path.Base(source)
+		if !isGeneration(source) {
+			// legacy names can have / in them
+			name = obj.kind + "/" + strings.ReplaceAll(strings.TrimPrefix(source, obj.kind+"/"), "/", "_")
+		}
+	case "history":
+		name = "history/" + strings.TrimPrefix(source, historyPrefix)
+	case "audit":
+		name = "audit/" + path.Base(source)
+	case "published":
+		name = "published/" + strings.TrimPrefix(source, "public/")
+	default:
+		name = "objects/" + source
+	}
+
+	var content bytes.Buffer
+	hash := sha256.New()
+	fw, err := archive.create(name, info.Size, info.LastModified)
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+		return
+	}
+	writers := []io.Writer{fw, hash}
+	if obj.kind == "data" || obj.kind == "backup" {
+		// keep the data to convert it to JSON afterwards
+		writers = append(writers, &content)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+		return
+	}
+	files = append(files, ManifestFile{
+		Name:         name,
+		Object:       source,
+		Kind:         obj.kind,
+		Size:         size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+	})
+	if content.Len() == 0 {
+		return
+	}
+
+	// the JSON version is best effort, the data as it's stored is always exported
+	var data any
+	var decodeErr error
+	if isGeneration(source) {
+		data, decodeErr = generationJSON(&content)
+	} else {
+		data, _, decodeErr = decodeMap(&content)
+	}
+	if decodeErr != nil {
+		return
+	}
+	body, jsonErr := json.MarshalIndent(data, "", "  ")
+	if jsonErr != nil {
+		return
+	}
+	jsonName := strings.TrimSuffix(name, ".gob") + ".json"
+	fw, err = archive.create(jsonName, int64(len(body)), info.LastModified)
+	if err == nil {
+		_, err = fw.Write(body)
+	}
+	if err != nil {
+		log.Println("Cannot write archive:", err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	files = append(files, ManifestFile{
+		Name:         jsonName,
+		Object:       source,
+		Kind:         obj.kind,
+		Size:         int64(len(body)),
+		ContentType:  "application/json",
+		LastModified: info.LastModified,
+		SHA256:       hex.EncodeToString(sum[:]),
+	})
+	return
+}
+
+// get what's in a backup generation, in a form that can be converted to JSON
+func generationJSON(r io.Reader) (data any, err error) {
+	header, values, _, err := decodeGeneration(r, true)
+	if err != nil {
+		return
+	}
+	return struct {
+		Full    bool           `json:"full"`
+		Values  map[string]any `json:"values"`
+		Removed []string       `json:"removed,omitempty"`
+	}{header.Full, values, header.Removed}, nil
+}
+
+// PurgeOptions are the options for PurgeUser
+type PurgeOptions struct {
+	// Keep the audit records of changes to the data for the unique ID and to
+	// the files it published, and write records of the files the purge
+	// unpublishes. The records hold the unique ID, keys, filenames and actors,
+	// so keeping them keeps personal data
+	KeepAudit bool
+}
+
+// PurgeUser removes everything held about a user
+// Unlike DeleteAll, which leaves an empty map behind, this removes the data,
+// backup, history and audit records for the unique ID, the raw objects and
+// published files owned by the unique ID (see PutOptions.Owner) and the audit
+// records of those files, and takes the unique ID out of the backup manifests,
+// checkpoints and job statuses in the backup store
+// Options can be given to keep the audit records
+func (s *Store) PurgeUser(ctx context.Context, uid string, opts ...PurgeOptions) (err error) {
+	ctx, done := s.longOperation(ctx, "PurgeUser")
+	defer done(&err)
+	err = ValidateUID(uid)
+	if err != nil {
+		return
+	}
+	var options PurgeOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if !options.KeepAudit {
+		// records of what the purge removes would hold what it's removing
+		ctx = withoutAudit(ctx)
+	}
+	objects, err := s.userObjects(ctx, uid)
+	if err != nil {
+		return
+	}
+	published := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.kind == "audit" && options.KeepAudit {
+			continue
+		}
+		switch obj.kind {
+		case "published":
+			filename := strings.TrimPrefix(obj.info.Key, "public/")
+			published[filename] = true
+			// content-addressed blobs are shared, so they are only removed when nothing refers to them
+			err = s.Unpublish(ctx, filename)
+		default:
+			err = obj.store.removeObject(ctx, obj.info.Key)
+		}
+		if err == nil && (obj.kind == "object" || obj.kind == "published") {
+			err = s.removeObject(ctx, ownerObjects(uid)+obj.info.Key)
+		}
+		if err != nil {
+			log.Println("Cannot remove object:", err)
+			return
+		}
+	}
+	if !options.KeepAudit {
+		err = s.purgePublishedAudit(ctx, uid, published)
+		if err != nil {
+			return
+		}
+	}
+	return s.backups().purgeManifests(ctx, uid)
+}
+
+// remove the audit records of the files a unique ID published
+// Records written before they had owners are found by the filenames of the
+// files being purged
+func (s *Store) purgePublishedAudit(ctx context.Context, uid string, published map[string]bool) (err error) {
+	for info := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: auditObjects(""), Recursive: true}) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list audit records:", err)
+			return
+		}
+		var rec AuditRecord
+		rec, err = s.readRecord(ctx, info.Key)
+		if err != nil {
+			return
+		}
+		if rec.Owner != uid && !(rec.Owner == "" && published[rec.Key]) {
+			continue
+		}
+		err = s.removeObject(ctx, info.Key)
+		if err != nil {
+			log.Println("Cannot remove audit record:", err)
+			return
+		}
+	}
+	return
+}
+
+// take a unique ID out of the backup manifests, checkpoints and job statuses
+// in the store, which list the unique IDs that were backed up or failed to be
+func (s *Store) purgeManifests(ctx context.Context, uid string) (err error) {
+	for info := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: manifestsPrefix, Recursive: true}) {
+		if info.Err != nil {
+			err = info.Err
+			log.Println("Cannot list manifests:", err)
+			return
+		}
+		switch {
+		case strings.HasPrefix(info.Key, manifestsPrefix+"backup/"):
+			var manifest BackupManifest
+			err = s.rewriteJSON(ctx, info.Key, &manifest, func() bool {
+				return manifest.without(uid)
+			})
+		case strings.HasPrefix(info.Key, manifestsPrefix+"checkpoints/"):
+			var saved checkpoint
+			err = s.rewriteJSON(ctx, info.Key, &saved, func() bool {
+				var restored bool
+				saved.Restored, restored = without(saved.Restored, uid)
+				return saved.Manifest.without(uid) || restored
+			})
+		case strings.HasPrefix(info.Key, manifestsPrefix+"jobs/"):
+			var status JobStatus
+			err = s.rewriteJSON(ctx, info.Key, &status, func() (changed bool) {
+				status.Failures, changed = withoutFailures(status.Failures, uid)
+				return
+			})
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// read an object holding JSON into v, change it with fn, and write it back
+// if fn says it changed
+func (s *Store) rewriteJSON(ctx context.Context, objectName string, v any, fn func() bool) (err error) {
+	found, err := s.readJSON(ctx, objectName, v)
+	if err != nil || !found || !fn() {
+		return
+	}
+	return s.writeJSON(ctx, objectName, v)
+}
+
+// take a unique ID out of the manifest, returning whether it was in it
+func (m *BackupManifest) without(uid string) (changed bool) {
+	var backups []ManifestBackup
+	for _, b := range m.Backups {
+		if b.UID == uid {
+			changed = true
+		} else {
+			backups = append(backups, b)
+		}
+	}
+	m.Backups = backups
+	var failed bool
+	m.Failures, failed = withoutFailures(m.Failures, uid)
+	return changed || failed
+}
+
+// take the failures of a unique ID out of a list of them
+func withoutFailures(failures []ManifestFailure, uid string) (result []ManifestFailure, changed bool) {
+	for _, f := range failures {
+		if f.UID == uid {
+			changed = true
+		} else {
+			result = append(result, f)
+		}
+	}
+	return
+}
+
+// take a unique ID out of a list of them
+func without(uids []string, uid string) (result []string, changed bool) {
+	for _, u := range uids {
+		if u == uid {
+			changed = true
+		} else {
+			result = append(result, u)
+		}
+	}
+	return
+}
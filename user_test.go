@@ -0,0 +1,205 @@
+package gost
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// put data, a backup, an object and a published file for a user
+func setupUser(t *testing.T, store *Store, uid string) {
+	ctx := context.Background()
+	err := store.Put(ctx, uid, "123", "hello world!")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, uid)
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	err = store.Collection("invoices").Put(ctx, "user-invoice", "an invoice", PutOptions{Owner: uid})
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	imageBytes, err := os.ReadFile("test.png")
+	if err != nil {
+		t.Errorf("Failed to read test.png: %v", err)
+	}
+	_, _, err = store.PublishContent(ctx, "user-avatar.png", "image/png", imageBytes, PutOptions{Owner: uid})
+	if err != nil {
+		t.Errorf("Failed to publish: %v", err)
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithAudit())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	uid := "export-user@example.com"
+	setupUser(t, store, uid)
+
+	var buf bytes.Buffer
+	manifest, err := store.ExportUser(context.Background(), uid, &buf, ExportZip)
+	if err != nil {
+		t.Errorf("Failed to export: %v", err)
+	}
+	kinds := make(map[string]int)
+	for _, file := range manifest.Files {
+		kinds[file.Kind]++
+	}
+	// data and backup are exported as they are stored and as JSON
+	if kinds["data"] != 2 || kinds["backup"] != 2 || kinds["object"] != 1 || kinds["published"] != 1 ||
+		kinds["audit"] == 0 {
+		t.Errorf("Failed to export the right files: %+v", manifest.Files)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	if files["manifest.json"] == nil || len(files) != len(manifest.Files)+1 {
+		t.Errorf("Failed to write the right files: %v", files)
+	}
+	r, err := files["data/"+encode(uid)+".json"].Open()
+	if err != nil {
+		t.Fatalf("Failed to open data: %v", err)
+	}
+	defer r.Close()
+	body, _ := io.ReadAll(r)
+	var data map[string]any
+	err = json.Unmarshal(body, &data)
+	if err != nil || data["123"] != "hello world!" {
+		t.Errorf("Failed to export data as JSON: %s, %v", body, err)
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithAudit())
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	uid := "purge-user@example.com"
+	since := time.Now()
+	setupUser(t, store, uid)
+	manifest := BackupManifest{
+		ID:       manifestsPrefix + "backup/purge-test.json",
+		Backups:  []ManifestBackup{{UID: uid, Generation: 1}, {UID: "someone-else", Generation: 1}},
+		Failures: []ManifestFailure{{UID: uid, Error: "failed"}},
+	}
+	err = store.backups().writeJSON(ctx, manifest.ID, manifest)
+	if err != nil {
+		t.Errorf("Failed to write manifest: %v", err)
+	}
+	err = store.backups().writeJSON(ctx, jobObject("purge-test"), JobStatus{Name: "purge-test", Failures: manifest.Failures})
+	if err != nil {
+		t.Errorf("Failed to write job status: %v", err)
+	}
+
+	err = store.PurgeUser(ctx, uid)
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	for _, objectName := range []string{name(uid), backup(uid), "objects/invoices/user-invoice", "public/user-avatar.png"} {
+		_, err = store.client.StatObject(ctx, store.bucket, objectName, minio.StatObjectOptions{})
+		if !isNoSuchKey(err) {
+			t.Errorf("%v should have been removed: %v", objectName, err)
+		}
+	}
+	objects, err := store.userObjects(ctx, uid)
+	if err != nil || len(objects) != 0 {
+		t.Errorf("Nothing should be left for the user: %v, %v", objects, err)
+	}
+	// the audit records hold the unique ID, and the filenames of the files it published
+	records, err := store.AuditLog(ctx, "", since)
+	if err != nil {
+		t.Errorf("Failed to read the audit log: %v", err)
+	}
+	for _, rec := range records {
+		if rec.Owner == uid || rec.Key == "user-avatar.png" {
+			t.Errorf("Audit record of a published file should have been removed: %+v", rec)
+		}
+	}
+	// and so do manifests and job statuses
+	var purged BackupManifest
+	_, err = store.backups().readJSON(ctx, manifest.ID, &purged)
+	if err != nil || len(purged.Backups) != 1 || purged.Backups[0].UID != "someone-else" || len(purged.Failures) != 0 {
+		t.Errorf("Unique ID should have been taken out of the manifest: %+v, %v", purged, err)
+	}
+	var status JobStatus
+	_, err = store.backups().readJSON(ctx, jobObject("purge-test"), &status)
+	if err != nil || len(status.Failures) != 0 {
+		t.Errorf("Unique ID should have been taken out of the job status: %+v, %v", status, err)
+	}
+
+	// audit records can be kept
+	setupUser(t, store, uid)
+	err = store.PurgeUser(ctx, uid, PurgeOptions{KeepAudit: true})
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+	records, err = store.AuditLog(ctx, uid, time.Time{})
+	if err != nil || len(records) == 0 {
+		t.Errorf("Audit records should have been kept: %v, %v", records, err)
+	}
+	err = store.PurgeUser(ctx, uid)
+	if err != nil {
+		t.Errorf("Failed to purge: %v", err)
+	}
+}
+
+func TestOwnerIndex(t *testing.T) {
+	setup()
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket)
+	if err != nil {
+		t.Errorf("Failed to initialise a store: %v", err)
+	}
+	ctx := context.Background()
+	uid := "owner-index@example.com"
+	// an object put before objects were indexed
+	_, err = store.client.PutObject(ctx, store.bucket, "owner-index-test", bytes.NewReader([]byte("hello")), 5,
+		minio.PutObjectOptions{UserMetadata: map[string]string{ownerMeta: encode(uid)}})
+	if err != nil {
+		t.Errorf("Failed to put object: %v", err)
+	}
+	objects, err := store.userObjects(ctx, uid)
+	if err != nil || len(objects) != 0 {
+		t.Errorf("Object that isn't indexed should not be found: %v, %v", objects, err)
+	}
+	err = store.IndexOwners(ctx)
+	if err != nil {
+		t.Errorf("Failed to index owners: %v", err)
+	}
+	objects, err = store.userObjects(ctx, uid)
+	if err != nil || len(objects) != 1 || objects[0].info.Key != "owner-index-test" {
+		t.Errorf("Failed to find the indexed object: %v, %v", objects, err)
+	}
+
+	// the entry of an object that was removed is removed too
+	err = store.DeleteObject(ctx, "owner-index-test")
+	if err != nil {
+		t.Errorf("Failed to delete object: %v", err)
+	}
+	objects, err = store.userObjects(ctx, uid)
+	if err != nil || len(objects) != 0 {
+		t.Errorf("Removed object should not be found: %v, %v", objects, err)
+	}
+	_, err = store.statObject(ctx, ownerObjects(uid)+"owner-index-test")
+	if !isNoSuchKey(err) {
+		t.Errorf("Index entry of a removed object should be removed: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// user metadata with the SHA-256 checksum of a backup generation
+const backupSumMeta = "Gost-Backup-Sha256"
+
+// ErrCorruptBackup is returned when a backup fails verification
+var ErrCorruptBackup = errors.New("gost: corrupt backup")
+
+// VerifyReport lists the unique IDs whose backups failed verification in VerifyAll
+type VerifyReport struct {
+	// the number of unique IDs whose backups were checked
+	Checked  int
+	Failures []ManifestFailure `json:",omitempty"`
+}
+
+// get the SHA-256 checksum of a backup
+func backupSum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// check a backup against the checksum and codec version in its metadata
+// Backups taken before they had checksums are only checked by decoding them
+func checkBackup(objectName string, metadata map[string]string, body []byte) (err error) {
+	if v, ok := metadata[codecMeta]; ok {
+		version, convErr := strconv.Atoi(v)
+		if convErr != nil || version > codecVersion {
+			return fmt.Errorf("%w: %s is stored in codec version %s, this version of gost reads up to %d", ErrCorruptBackup, objectName, v, codecVersion)
+		}
+	}
+	sum, ok := metadata[backupSumMeta]
+	if !ok {
+		return
+	}
+	if actual := backupSum(body); actual != sum {
+		return fmt.Errorf("%w: %s has checksum %s, it should be %s", ErrCorruptBackup, objectName, actual, sum)
+	}
+	return
+}
+
+// check every diff in a chain of generations directly follows the one before it
+func checkChain(gens []BackupGeneration) (err error) {
+	for i, g := range gens {
+		if g.Full {
+			continue
+		}
+		if i == 0 || gens[i-1].Generation != g.Generation-1 {
+			return fmt.Errorf("%w: backup generation %d is missing the generation before it", ErrCorruptBackup, g.Generation)
+		}
+	}
+	return
+}
+
+// VerifyBackup checks every backup generation for a given unique ID, by
+// checking its checksum and codec version and decoding it
+// The error wraps ErrCorruptBackup if a generation is damaged, or is
+// ErrUnregisteredType if it has values of types that aren't registered
+func (s *Store) VerifyBackup(ctx context.Context, uid string) (err error) {
+	ctx, done := s.operation(ctx, "VerifyBackup")
+	defer done(&err)
+	return s.backups().verifyBackup(ctx, uid)
+}
+
+// check every backup generation for a given unique ID in this store
+func (s *Store) verifyBackup(ctx context.Context, uid string) (err error) {
+	gens, err := s.generations(ctx, uid)
+	if err != nil {
+		return
+	}
+	err = checkChain(gens)
+	if err != nil {
+		return
+	}
+	for _, g := range gens {
+		err = s.verifyGeneration(ctx, g)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// check a backup generation by checking its checksum and decoding it
+func (s *Store) verifyGeneration(ctx context.Context, g BackupGeneration) (err error) {
+	obj, err := s.openObject(ctx, g.Object, minio.GetObjectOptions{})
+	if err != nil {
+		log.Println("Cannot get backup:", err)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		log.Println("Cannot check backup:", err)
+		return
+	}
+	body, err := io.ReadAll(obj)
+	if err != nil {
+		log.Println("Cannot read backup:", err)
+		return
+	}
+	err = checkBackup(g.Object, info.UserMetadata, body)
+	if err != nil {
+		return
+	}
+	if g.Generation == 0 {
+		_, _, err = decodeMap(bytes.NewReader(body))
+	} else {
+		_, _, _, err = decodeGeneration(bytes.NewReader(body), true)
+	}
+	if err != nil && !errors.Is(err, ErrUnregisteredType) {
+		err = fmt.Errorf("%w: %s cannot be decoded: %v", ErrCorruptBackup, g.Object, err)
+	}
+	return inObject(err, g.Object)
+}
+
+// VerifyAll checks the backups of every unique ID that has any, the way
+// VerifyBackup does, and reports the unique IDs whose backups fail
+// The Concurrency, Progress and Store options are used, the others are
+// ignored. The error is only set if the job itself fails, like when the
+// context is cancelled
+func (s *Store) VerifyAll(ctx context.Context, opts BulkOptions) (report VerifyReport, err error) {
+	ctx, done := s.longOperation(ctx, "VerifyAll")
+	defer done(&err)
+	source := s.bulkStore(opts)
+	opts.Checkpoint = ""
+	uids, err := source.backupUIDs(ctx)
+	if err != nil {
+		return
+	}
+	var mutex sync.Mutex
+	err = s.bulk(ctx, uids, nil, opts, func(uid string) (err error) {
+		err = source.verifyBackup(ctx, uid)
+		mutex.Lock()
+		defer mutex.Unlock()
+		report.Checked++
+		if err != nil {
+			report.Failures = append(report.Failures, ManifestFailure{uid, err.Error()})
+		}
+		return
+	}, nil)
+	sort.Slice(report.Failures, func(i, j int) bool { return report.Failures[i].UID < report.Failures[j].UID })
+	return
+}
+
+// list the unique IDs that have backups in this store
+func (s *Store) backupUIDs(ctx context.Context) (uids []string, err error) {
+	seen := make(map[string]bool)
+	for obj := range s.listObjects(ctx, minio.ListObjectsOptions{Prefix: "backup/", Recursive: true}) {
+		if obj.Err != nil {
+			err = obj.Err
+			log.Println("Cannot list backups:", err)
+			return
+		}
+		uid, decodeErr := backupUID(obj.Key)
+		if decodeErr != nil || seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	return
+}
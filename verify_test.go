@@ -0,0 +1,91 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	setup()
+	backups, err := NewStore(key, secret, endpoint, useSSL, region, bucket+"-verify")
+	if err != nil {
+		t.Errorf("Failed to create backup store: %v", err)
+	}
+	store, err := NewStore(key, secret, endpoint, useSSL, region, bucket, WithBackupStore(backups))
+	if err != nil {
+		t.Errorf("Failed to create store: %v", err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "verify-test", "a", "first")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Backup(ctx, "verify-test")
+	if err != nil {
+		t.Errorf("Failed to backup: %v", err)
+	}
+	err = store.VerifyBackup(ctx, "verify-test")
+	if err != nil {
+		t.Errorf("Failed to verify: %v", err)
+	}
+
+	// truncate the backup, keeping its metadata
+	gens, err := store.Generations(ctx, "verify-test")
+	if err != nil || len(gens) == 0 {
+		t.Fatalf("Failed to list generations: %v", err)
+	}
+	last := gens[len(gens)-1]
+	obj, err := backups.client.GetObject(ctx, backups.bucket, last.Object, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get backup: %v", err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		t.Fatalf("Failed to check backup: %v", err)
+	}
+	body := make([]byte, info.Size/2)
+	_, err = io.ReadFull(obj, body)
+	obj.Close()
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	_, err = backups.client.PutObject(ctx, backups.bucket, last.Object, bytes.NewReader(body), int64(len(body)),
+		minio.PutObjectOptions{UserMetadata: info.UserMetadata})
+	if err != nil {
+		t.Fatalf("Failed to corrupt backup: %v", err)
+	}
+
+	err = store.VerifyBackup(ctx, "verify-test")
+	if !errors.Is(err, ErrCorruptBackup) {
+		t.Errorf("Failed to find the corrupt backup: %v", err)
+	}
+	report, err := store.VerifyAll(ctx, BulkOptions{})
+	if err != nil {
+		t.Errorf("Failed to verify all: %v", err)
+	}
+	if report.Checked == 0 || len(report.Failures) != 1 || report.Failures[0].UID != "verify-test" {
+		t.Errorf("Failed to report the corrupt backup: %+v", report)
+	}
+
+	// restoring from the corrupt backup should leave the data as it is
+	err = store.Put(ctx, "verify-test", "a", "second")
+	if err != nil {
+		t.Errorf("Failed to store: %v", err)
+	}
+	err = store.Restore(ctx, "verify-test")
+	if !errors.Is(err, ErrCorruptBackup) {
+		t.Errorf("Restore should refuse a corrupt backup: %v", err)
+	}
+	value, err := store.Get(ctx, "verify-test", "a")
+	if err != nil {
+		t.Errorf("Failed to get: %v", err)
+	}
+	if value != "second" {
+		t.Errorf("Restore should leave the data as it is: %v", value)
+	}
+}